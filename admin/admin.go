@@ -0,0 +1,231 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mhanygin/broforce/bus"
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+	"github.com/mhanygin/broforce/metrics"
+	"github.com/mhanygin/broforce/tasks"
+)
+
+//config section
+//
+//admin:
+//  host: 127.0.0.1
+//  port: 8090
+//
+
+const (
+	defaultHost = "127.0.0.1"
+	defaultPort = 8090
+)
+
+// Server is embedded HTTP server with JSON endpoints describing
+// running broforce.
+type Server struct {
+	bus        *bus.EventsBus
+	supervisor *bus.Supervisor
+	mux        *http.ServeMux
+	server     *http.Server
+}
+
+func New(cfg config.ConfigData, b *bus.EventsBus, s *bus.Supervisor) *Server {
+	p := &Server{
+		bus:        b,
+		supervisor: s,
+		mux:        http.NewServeMux()}
+	p.mux.HandleFunc("/tasks", p.tasks)
+	p.mux.HandleFunc("/adapters", p.adapters)
+	p.mux.HandleFunc("/subscriptions", p.subscriptions)
+	p.mux.HandleFunc("/subjects", p.subjects)
+	p.mux.HandleFunc("/errors", p.errors)
+	p.mux.HandleFunc("/events", p.events)
+	p.mux.HandleFunc("/events/replay", p.replay)
+	p.mux.HandleFunc("/publish", p.publish)
+	p.mux.Handle("/metrics", metrics.Handler())
+	p.server = &http.Server{
+		Addr:    address(cfg),
+		Handler: p.mux}
+	return p
+}
+
+func address(cfg config.ConfigData) string {
+	return fmt.Sprintf("%s:%d", cfg.GetStringOr("host", defaultHost), cfg.GetIntOr("port", defaultPort))
+}
+
+// URL returns base URL of admin server of running instance, used by
+// commands talking to it.
+func URL(cfg config.ConfigData) string {
+	return fmt.Sprintf("http://%s", address(cfg))
+}
+
+// Handle adds endpoint to admin server, must be called before Start.
+func (p *Server) Handle(pattern string, handler http.Handler) {
+	p.mux.Handle(pattern, handler)
+}
+
+func (p *Server) Start() {
+	go func() {
+		logger.Log.Infof("admin: listen %s", p.server.Addr)
+		if err := p.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Log.Errorf("admin: %v", err)
+		}
+	}()
+}
+
+func (p *Server) Stop(ctx context.Context) error {
+	return p.server.Shutdown(ctx)
+}
+
+func reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Log.Errorf("admin: %v", err)
+	}
+}
+
+func (p *Server) tasks(w http.ResponseWriter, r *http.Request) {
+	types := make([]string, 0)
+	for n := range tasks.GetPool() {
+		types = append(types, n)
+	}
+	sort.Strings(types)
+	reply(w, struct {
+		Types     []string        `json:"types"`
+		Instances []bus.TaskState `json:"instances"`
+	}{types, p.supervisor.States()})
+}
+
+func (p *Server) adapters(w http.ResponseWriter, r *http.Request) {
+	reply(w, bus.GetAdapters())
+}
+
+func (p *Server) subscriptions(w http.ResponseWriter, r *http.Request) {
+	result := make(map[string]map[string][]string)
+	for _, a := range bus.GetAdapters() {
+		for subject, names := range a.Subscriptions {
+			if _, ok := result[subject]; !ok {
+				result[subject] = make(map[string][]string)
+			}
+			result[subject][a.Name] = names
+		}
+	}
+	reply(w, result)
+}
+
+func (p *Server) subjects(w http.ResponseWriter, r *http.Request) {
+	reply(w, struct {
+		Published map[string]uint64 `json:"published"`
+		InFlight  int               `json:"in-flight"`
+	}{p.bus.Published(), p.bus.InFlight()})
+}
+
+func (p *Server) errors(w http.ResponseWriter, r *http.Request) {
+	reply(w, logger.RecentErrors())
+}
+
+func (p *Server) eventQuery(w http.ResponseWriter, r *http.Request) (*bus.EventStore, bus.EventQuery, bool) {
+	store := bus.GetEventStore()
+	if store == nil {
+		http.Error(w, "event store is not configured", http.StatusNotFound)
+		return nil, bus.EventQuery{}, false
+	}
+	q, err := bus.NewEventQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, q, false
+	}
+	return store, q, true
+}
+
+func (p *Server) events(w http.ResponseWriter, r *http.Request) {
+	store, q, ok := p.eventQuery(w, r)
+	if !ok {
+		return
+	}
+	list, err := store.Query(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	reply(w, list)
+}
+
+func (p *Server) replay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	store, q, ok := p.eventQuery(w, r)
+	if !ok {
+		return
+	}
+	ids, err := store.Replay(p.bus, q, r.URL.Query().Get("all") == "true")
+	result := struct {
+		Replayed []string `json:"replayed"`
+		Error    string   `json:"error,omitempty"`
+	}{Replayed: ids}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	reply(w, result)
+}
+
+// publish publishes event with request body as data, parameters are
+// subject, coding, trace and header (`key=value`, repeated).
+func (p *Server) publish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	if len(q.Get("subject")) == 0 {
+		http.Error(w, "subject required", http.StatusBadRequest)
+		return
+	}
+	coding, trace := q.Get("coding"), q.Get("trace")
+	if len(coding) == 0 {
+		coding = bus.JsonCoding
+	}
+	if _, err := bus.GetCodec(coding); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(trace) == 0 {
+		trace = bus.NewUUID()
+	}
+	e := bus.NewEvent(trace, q.Get("subject"), coding)
+	for _, h := range q["header"] {
+		kv := strings.SplitN(h, "=", 2)
+		if len(kv) != 2 {
+			http.Error(w, fmt.Sprintf("header %s: key=value required", h), http.StatusBadRequest)
+			return
+		}
+		e.SetHeader(kv[0], kv[1])
+	}
+	if len(e.Source()) == 0 {
+		e.SetHeader(bus.SourceHeader, "admin")
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	e.Data = data
+	if err := p.bus.Publish(*e); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	reply(w, struct {
+		ID    string `json:"id"`
+		Trace string `json:"trace"`
+	}{e.ID(), e.Trace})
+}
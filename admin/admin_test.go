@@ -0,0 +1,80 @@
+package admin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mhanygin/broforce/bus"
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+)
+
+func TestServer(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("/tmp", "config_")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	cfg := config.New(tmpfile.Name(), config.YAMLAdapter)
+	logger.New(cfg.Get("logger"))
+
+	p := New(cfg.Get("admin"), &bus.EventsBus{}, bus.NewSupervisor())
+	get := func(path string, v interface{}) {
+		w := httptest.NewRecorder()
+		p.mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), v))
+	}
+
+	tasks := struct {
+		Types     []string        `json:"types"`
+		Instances []bus.TaskState `json:"instances"`
+	}{}
+	get("/tasks", &tasks)
+	assert.Contains(t, tasks.Types, "serve")
+	assert.Equal(t, 0, len(tasks.Instances))
+
+	adapters := make([]bus.AdapterInfo, 0)
+	get("/adapters", &adapters)
+	names := make([]string, 0)
+	for _, a := range adapters {
+		names = append(names, a.Name)
+	}
+	assert.Contains(t, names, "simple")
+
+	subjects := struct {
+		Published map[string]uint64 `json:"published"`
+	}{}
+	get("/subjects", &subjects)
+
+	logger.Log.Error("admin test error")
+	errors := make([]logger.RecentEntry, 0)
+	get("/errors", &errors)
+	assert.Equal(t, "admin test error", errors[len(errors)-1].Message)
+
+	w := httptest.NewRecorder()
+	p.mux.ServeHTTP(w, httptest.NewRequest("GET", "/events?subject=serve.*", nil))
+	assert.Equal(t, 404, w.Code)
+
+	assert.Equal(t, "http://127.0.0.1:8090", URL(cfg.Get("admin")))
+
+	post := func(path string) int {
+		w := httptest.NewRecorder()
+		p.mux.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader("{}")))
+		return w.Code
+	}
+	assert.Equal(t, 400, post("/publish"))
+	assert.Equal(t, 400, post("/publish?subject=serve.manifest&header=broken"))
+	assert.Equal(t, 400, post("/publish?subject=serve.manifest&coding=xml"))
+	assert.Equal(t, 500, post("/publish?subject=no.adapter"))
+}
@@ -1,28 +1,76 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
-	"runtime"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"gopkg.in/alecthomas/kingpin.v2"
 
+	"github.com/mhanygin/broforce/admin"
 	"github.com/mhanygin/broforce/bus"
 	"github.com/mhanygin/broforce/config"
 	"github.com/mhanygin/broforce/logger"
 	"github.com/mhanygin/broforce/tasks"
+	"github.com/mhanygin/broforce/tracing"
 )
 
 var Version = ""
 
+const defaultDrainTimeout = 30
+
+// sections are top-level config sections besides tasks.
+var sections = []string{"bus", "logger", "admin", "tracing", "reload"}
+
 func main() {
 	cfgPath := kingpin.Flag("config", "Path to config.yml file.").Default("config.yml").String()
+	profile := kingpin.Flag("profile", "Config profile overlay, e.g. production.").Envar("BROFORCE_PROFILE").String()
 	show := kingpin.Flag("show", "Show all task names.").Bool()
-	allow := kingpin.Flag("allow", "list of allowed tasks").Default(tasks.GetPoolString()).String()
+	allow := kingpin.Flag("allow", "list of allowed tasks, all tasks by default, required by emit --local").String()
+
+	runCmd := kingpin.Command("run", "Run allowed tasks.").Default()
+
+	deadLetterCmd := kingpin.Command("dead-letter", "Events failed by handlers.")
+	deadLetterList := deadLetterCmd.Command("list", "List dead-letter events.")
+	deadLetterShow := deadLetterCmd.Command("show", "Show dead-letter event.")
+	deadLetterShowID := deadLetterShow.Arg("id", "Dead-letter event ID.").Required().String()
+	deadLetterRepublish := deadLetterCmd.Command("republish", "Re-publish dead-letter event by running instance.")
+	deadLetterRepublishID := deadLetterRepublish.Arg("id", "Dead-letter event ID.").Required().String()
+
+	eventsCmd := kingpin.Command("events", "Events recorded by event store.")
+	eventsList := eventsCmd.Command("list", "List recorded events.")
+	eventsListQuery := eventQueryFlags(eventsList)
+	eventsShow := eventsCmd.Command("show", "Show recorded event.")
+	eventsShowID := eventsShow.Arg("id", "Event ID.").Required().String()
+	eventsReplay := eventsCmd.Command("replay", "Re-publish recorded events by running instance.")
+	eventsReplayQuery := eventQueryFlags(eventsReplay)
+	eventsReplayAll := eventsReplay.Flag("all", "Replay also events derived from other events.").Bool()
+
+	emit := kingpin.Command("emit", "Publish event by running instance or, with --local, by allowed tasks on in-process bus.")
+	emitArgs := &emitParams{}
+	emit.Arg("subject", "Event subject.").Required().StringVar(&emitArgs.subject)
+	emit.Flag("coding", "Event coding.").Default(bus.JsonCoding).StringVar(&emitArgs.coding)
+	emit.Flag("trace", "Event trace, new one by default.").StringVar(&emitArgs.trace)
+	emit.Flag("data", "File with event data, - for stdin.").StringVar(&emitArgs.data)
+	emit.Flag("header", "Event header key=value.").StringMapVar(&emitArgs.headers)
+	emit.Flag("local", "Run allowed tasks on in-process bus instead of running instance.").BoolVar(&emitArgs.local)
+
+	checkConfig := kingpin.Command("check-config", "Validate config of allowed tasks.")
+
+	configCmd := kingpin.Command("config", "Effective config.")
+	configDump := configCmd.Command("dump", "Print config with includes and profile merged, secrets masked.")
 
 	kingpin.Version(Version)
-	kingpin.Parse()
+	cmd := kingpin.Parse()
 
 	if *show {
 		fmt.Println("name bus adapters:")
@@ -37,33 +85,393 @@ func main() {
 	}
 
 	if _, err := os.Stat(*cfgPath); os.IsNotExist(err) {
-		fmt.Errorf("%v", err)
+		fmt.Println("Error:", err)
 		return
 	}
-	allowTasks := fmt.Sprintf(",%s,", *allow)
-	c := config.New(*cfgPath, config.YAMLAdapter)
+	c := config.NewProfile(*cfgPath, config.YAMLAdapter, *profile)
 	if c == nil {
 		fmt.Println("Error: config not create")
 		return
 	}
 	logger.New(c.Get("logger"))
+	config.ErrorHandler = func(err error) {
+		logger.Log.Errorf("config: %v", err)
+	}
+
+	allowed := *allow
+	if len(allowed) == 0 {
+		allowed = tasks.GetPoolString()
+	}
 
+	var err error
+	switch cmd {
+	case runCmd.FullCommand():
+		run(c, allowed)
+	case deadLetterList.FullCommand():
+		err = deadLetterListCmd(c)
+	case deadLetterShow.FullCommand():
+		err = deadLetterShowCmd(c, *deadLetterShowID)
+	case deadLetterRepublish.FullCommand():
+		err = deadLetterRepublishCmd(c, *deadLetterRepublishID)
+	case eventsList.FullCommand():
+		err = eventsListCmd(c, eventsListQuery)
+	case eventsShow.FullCommand():
+		err = eventsShowCmd(c, *eventsShowID)
+	case eventsReplay.FullCommand():
+		err = eventsReplayCmd(c, eventsReplayQuery, *eventsReplayAll)
+	case emit.FullCommand():
+		err = emitCmd(c, *allow, emitArgs)
+	case checkConfig.FullCommand():
+		if err = check(c, allowed); err == nil {
+			fmt.Println("config is valid")
+		}
+	case configDump.FullCommand():
+		var out []byte
+		if out, err = config.Dump(c); err == nil {
+			fmt.Print(string(out))
+		}
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
+
+//config section
+//
+//reload:
+//  interval: 5
+//
+
+// reload re-reads config and passes changed sections to tasks, invalid
+// config is rejected and the old one stays.
+func reload(c config.Config, allow string, supervisor *bus.Supervisor) {
+	logger.Log.Info("config: reload")
+	static := []string{"bus", "admin", "tracing"}
+	before := make(map[string]string)
+	for _, name := range static {
+		before[name] = c.Get(name).String()
+	}
+	if err := c.Reload(func(n config.Config) error {
+		errs := make(bus.Errors, 0)
+		for _, err := range tasks.Check(n, allow, sections...) {
+			if !config.IsWarning(err) {
+				errs = append(errs, err)
+			}
+		}
+		if len(errs) != 0 {
+			return errs
+		}
+		return nil
+	}); err != nil {
+		logger.Log.Errorf("config: rejected: %v", err)
+		return
+	}
+	for _, name := range static {
+		if c.Get(name).String() != before[name] {
+			logger.Log.Warnf("config: %s changed, restart required", name)
+		}
+	}
+	instances, err := tasks.GetInstances(c, allow)
+	if err != nil {
+		logger.Log.Errorf("config: %v", err)
+		return
+	}
+	sections := make(map[string]config.ConfigData)
+	for _, i := range instances {
+		sections[i.Name] = i.Config
+	}
+	if err := supervisor.Reload(sections); err != nil {
+		logger.Log.Errorf("config: %v", err)
+	}
+}
+
+// check prints problems of config, warnings do not fail it.
+func check(c config.Config, allow string) error {
+	failed := 0
+	for _, err := range tasks.Check(c, allow, sections...) {
+		if config.IsWarning(err) {
+			fmt.Println("warning:", err)
+			continue
+		}
+		fmt.Println("error:", err)
+		failed++
+	}
+	if failed != 0 {
+		return fmt.Errorf("config has %d errors", failed)
+	}
+	return nil
+}
+
+func run(c config.Config, allow string) {
 	logger.Log.Debugf("Config for bus: %v", c.Get("bus"))
 
+	if err := check(c, allow); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	instances, err := tasks.GetInstances(c, allow)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	if c.Exist("tracing") {
+		if err := tracing.New(c.Get("tracing")); err != nil {
+			logger.Log.Error(err)
+		}
+	}
+
+	stop, cancel := context.WithCancel(context.Background())
+	supervisor := bus.NewSupervisor()
+
 	b := bus.New(c.Get("bus"))
-	for n, s := range tasks.GetPool() {
-		if strings.Index(allowTasks, fmt.Sprintf(",%s,", n)) != -1 {
+	for _, i := range instances {
+		logger.Log.Debugf("Config for %s (%s): %v", i.Name, i.Type, i.Config)
+
+		supervisor.Start(i.Task, bus.Context{
+			Ctx:    stop,
+			Name:   i.Name,
+			Type:   i.Type,
+			Config: i.Config,
+			Log:    logger.Logger4Handler(i.Name, ""),
+			Bus:    b})
+	}
+
+	var adm *admin.Server
+	if c.Exist("admin") {
+		adm = admin.New(c.Get("admin"), b, supervisor)
+		adm.Start()
+	}
+
+	reloads := make(chan struct{}, 1)
+	if interval := c.Get("reload").GetIntOr("interval", 0); interval > 0 {
+		go config.Watch(stop, c.Files, time.Duration(interval)*time.Second, func() {
+			select {
+			case reloads <- struct{}{}:
+			default:
+			}
+		})
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for {
+		select {
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				reload(c, allow, supervisor)
+				continue
+			}
+			logger.Log.Infof("%v: shutdown", sig)
+		case <-reloads:
+			reload(c, allow, supervisor)
+			continue
+		}
+		break
+	}
 
-			logger.Log.Debugf("Config for %s: %v", n, c.Get(n))
+	timeout := time.Duration(c.Get("bus").GetIntOr("drain-timeout", defaultDrainTimeout)) * time.Second
+	drain, done := context.WithTimeout(context.Background(), timeout)
+	defer done()
 
-			go bus.SafeRun(s.Run, bus.SafeParams{Retry: 0, Delay: 0})(
-				bus.Context{
-					Name:   n,
-					Config: c.Get(n),
-					Log:    logger.Logger4Handler(n, ""),
-					Bus:    b})
+	cancel()
+	if err := supervisor.Stop(drain); err != nil {
+		logger.Log.Error(err)
+	}
+	if err := b.Shutdown(drain); err != nil {
+		logger.Log.Error(err)
+	}
+	if adm != nil {
+		if err := adm.Stop(drain); err != nil {
+			logger.Log.Error(err)
 		}
 	}
+	if err := tracing.Shutdown(drain); err != nil {
+		logger.Log.Error(err)
+	}
+	logger.Log.Info("stopped")
+}
 
-	runtime.Goexit()
+func deadLetterListCmd(c config.Config) error {
+	store, err := bus.NewDeadLetterStore(c.Get("bus").Get("dead-letter"))
+	if err != nil {
+		return err
+	}
+	list, err := store.List()
+	if err != nil {
+		return err
+	}
+	for _, dl := range list {
+		requeued := ""
+		if dl.Requeued {
+			requeued = " (requeued)"
+		}
+		fmt.Printf("%s %s %s %s attempts: %d%s\n   %s\n",
+			dl.ID,
+			dl.LastAttempt.Format(time.RFC3339),
+			dl.Handler,
+			dl.Event.Subject,
+			dl.Attempts,
+			requeued,
+			dl.Error)
+	}
+	return nil
+}
+
+func deadLetterShowCmd(c config.Config, id string) error {
+	store, err := bus.NewDeadLetterStore(c.Get("bus").Get("dead-letter"))
+	if err != nil {
+		return err
+	}
+	dl, err := store.Get(id)
+	if err != nil {
+		return err
+	}
+	fmt.Println("id:           ", dl.ID)
+	fmt.Println("handler:      ", dl.Handler)
+	fmt.Println("error:        ", dl.Error)
+	fmt.Println("attempts:     ", dl.Attempts)
+	fmt.Println("first attempt:", dl.FirstAttempt.Format(time.RFC3339))
+	fmt.Println("last attempt: ", dl.LastAttempt.Format(time.RFC3339))
+	fmt.Println("requeued:     ", dl.Requeued)
+	fmt.Println("trace:        ", dl.Event.Trace)
+	fmt.Println("subject:      ", dl.Event.Subject)
+	fmt.Println("coding:       ", dl.Event.Coding)
+	fmt.Println("data:")
+	fmt.Println(string(dl.Event.Data))
+	return nil
+}
+
+func deadLetterRepublishCmd(c config.Config, id string) error {
+	store, err := bus.NewDeadLetterStore(c.Get("bus").Get("dead-letter"))
+	if err != nil {
+		return err
+	}
+	if err := store.Requeue(id); err != nil {
+		return err
+	}
+	fmt.Printf("%s queued for re-publish\n", id)
+	return nil
+}
+
+type eventQuery struct {
+	id      *string
+	subject *string
+	trace   *string
+	from    *string
+	to      *string
+	limit   *int
+}
+
+func eventQueryFlags(cmd *kingpin.CmdClause) *eventQuery {
+	return &eventQuery{
+		id:      cmd.Flag("id", "Event ID.").String(),
+		subject: cmd.Flag("subject", "Subject, wildcards allowed.").String(),
+		trace:   cmd.Flag("trace", "Trace of events.").String(),
+		from:    cmd.Flag("from", "Recorded after, RFC3339.").String(),
+		to:      cmd.Flag("to", "Recorded before, RFC3339.").String(),
+		limit:   cmd.Flag("limit", "Max number of events.").Int()}
+}
+
+func (p *eventQuery) query() (bus.EventQuery, error) {
+	v := url.Values{}
+	v.Set("id", *p.id)
+	v.Set("subject", *p.subject)
+	v.Set("trace", *p.trace)
+	v.Set("from", *p.from)
+	v.Set("to", *p.to)
+	if *p.limit > 0 {
+		v.Set("limit", strconv.Itoa(*p.limit))
+	}
+	return bus.NewEventQuery(v)
+}
+
+func eventsListCmd(c config.Config, eq *eventQuery) error {
+	q, err := eq.query()
+	if err != nil {
+		return err
+	}
+	store, err := bus.NewEventStore(c.Get("bus").Get("event-store"))
+	if err != nil {
+		return err
+	}
+	list, err := store.Query(q)
+	if err != nil {
+		return err
+	}
+	for _, se := range list {
+		fmt.Printf("%s %s %s %s %s\n",
+			se.Event.ID(),
+			se.Stored.Format(time.RFC3339),
+			se.Event.Trace,
+			se.Event.Source(),
+			se.Event.Subject)
+	}
+	return nil
+}
+
+func eventsShowCmd(c config.Config, id string) error {
+	store, err := bus.NewEventStore(c.Get("bus").Get("event-store"))
+	if err != nil {
+		return err
+	}
+	list, err := store.Query(bus.EventQuery{ID: id, Limit: 1})
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		return fmt.Errorf("event %s not found", id)
+	}
+	se := list[0]
+	fmt.Println("id:     ", se.Event.ID())
+	fmt.Println("stored: ", se.Stored.Format(time.RFC3339))
+	fmt.Println("trace:  ", se.Event.Trace)
+	fmt.Println("subject:", se.Event.Subject)
+	fmt.Println("coding: ", se.Event.Coding)
+	fmt.Println("headers:")
+	for k, v := range se.Event.Headers {
+		fmt.Printf("  %s: %s\n", k, v)
+	}
+	fmt.Println("data:")
+	fmt.Println(string(se.Event.Data))
+	return nil
+}
+
+func eventsReplayCmd(c config.Config, eq *eventQuery, all bool) error {
+	q, err := eq.query()
+	if err != nil {
+		return err
+	}
+	if len(q.Values()) == 0 {
+		return fmt.Errorf("no filter, use --id, --subject, --trace, --from or --to")
+	}
+	v := q.Values()
+	if all {
+		v.Set("all", "true")
+	}
+	resp, err := http.Post(fmt.Sprintf("%s/events/replay?%s", admin.URL(c.Get("admin")), v.Encode()), "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	result := struct {
+		Replayed []string `json:"replayed"`
+		Error    string   `json:"error"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	for _, id := range result.Replayed {
+		fmt.Printf("%s replayed\n", id)
+	}
+	if len(result.Error) != 0 {
+		return fmt.Errorf("%s", result.Error)
+	}
+	return nil
 }
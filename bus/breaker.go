@@ -0,0 +1,145 @@
+package bus
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+	"github.com/mhanygin/broforce/metrics"
+)
+
+//config section of task
+//
+//manifest:
+//  breaker:
+//    threshold: 5
+//    timeout: 1m
+//
+
+const (
+	ClosedState   = "closed"
+	OpenState     = "open"
+	HalfOpenState = "half-open"
+
+	defaultBreakerThreshold = 5
+	defaultBreakerTimeout   = time.Minute
+)
+
+// CircuitState is data of CircuitEvent published on every state change.
+type CircuitState struct {
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Failures int    `json:"failures"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Breaker stops calls to failing integration: after threshold failures
+// in a row the circuit is open and calls fail at once, after timeout
+// one probe call is allowed (half-open), its result closes or opens
+// the circuit again.
+type Breaker struct {
+	name      string
+	threshold int
+	timeout   time.Duration
+	ctx       Context
+
+	lock     sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func NewBreaker(name string, cfg config.ConfigData, ctx Context) *Breaker {
+	p := &Breaker{
+		name:      name,
+		threshold: defaultBreakerThreshold,
+		timeout:   defaultBreakerTimeout,
+		ctx:       ctx,
+		state:     ClosedState}
+	if cfg != nil {
+		p.threshold = cfg.GetIntOr("threshold", defaultBreakerThreshold)
+		p.timeout = getDuration(cfg, "timeout", defaultBreakerTimeout)
+	}
+	return p
+}
+
+func (p *Breaker) State() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.state
+}
+
+// Do calls f unless the circuit is open. Rejected call returns
+// permanent error, so retry policies do not retry it.
+func (p *Breaker) Do(f func() error) error {
+	if err := p.allow(); err != nil {
+		return err
+	}
+	start := time.Now()
+	err := f()
+	metrics.External(p.name, start, err)
+	p.done(err)
+	return err
+}
+
+func (p *Breaker) allow() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.state == OpenState && time.Since(p.openedAt) >= p.timeout {
+		p.change(HalfOpenState, nil)
+	}
+	switch {
+	case p.state == OpenState, p.state == HalfOpenState && p.probing:
+		return Permanent(fmt.Errorf("circuit %s is %s", p.name, p.state))
+	case p.state == HalfOpenState:
+		p.probing = true
+	}
+	return nil
+}
+
+func (p *Breaker) done(err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.state == HalfOpenState {
+		p.probing = false
+	}
+	if err == nil || IsPermanent(err) {
+		p.failures = 0
+		if p.state != ClosedState {
+			p.change(ClosedState, nil)
+		}
+		return
+	}
+	p.failures++
+	if p.state == HalfOpenState || (p.state == ClosedState && p.failures >= p.threshold) {
+		p.openedAt = time.Now()
+		p.change(OpenState, err)
+	}
+}
+
+func (p *Breaker) change(state string, err error) {
+	p.state = state
+	cs := CircuitState{Name: p.name, State: state, Failures: p.failures}
+	if err != nil {
+		cs.Error = err.Error()
+	}
+	logger.Log.Warnf("circuit %s: %s (failures: %d)", p.name, state, p.failures)
+	if p.ctx.Bus == nil {
+		return
+	}
+	go func() {
+		event, err := NewEventWithData(NewUUID(), CircuitEvent, JsonCoding, cs)
+		if err != nil {
+			logger.Log.Error(err)
+			return
+		}
+		if err := p.ctx.Publish(*event); err != nil {
+			logger.Log.Errorf("circuit %s: %v", p.name, err)
+		}
+	}()
+}
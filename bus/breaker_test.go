@@ -0,0 +1,78 @@
+package bus
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+)
+
+func TestBreaker(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("/tmp", "config_")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	cfg := config.New(tmpfile.Name(), config.YAMLAdapter)
+	logger.New(cfg.Get("logger"))
+
+	fail := func() error { return fmt.Errorf("unavailable") }
+	ok := func() error { return nil }
+
+	t.Run("Open", func(t *testing.T) {
+		b := NewBreaker("test", cfg.Get("breaker"), Context{})
+		b.threshold = 3
+		for i := 0; i < 3; i++ {
+			b.Do(fail)
+		}
+		if b.State() != OpenState {
+			t.Errorf("%s != %s", b.State(), OpenState)
+		}
+		called := false
+		err := b.Do(func() error {
+			called = true
+			return nil
+		})
+		if called || !IsPermanent(err) {
+			t.Errorf("call is not rejected: %v", err)
+		}
+	})
+
+	t.Run("HalfOpen", func(t *testing.T) {
+		b := NewBreaker("test", cfg.Get("breaker"), Context{})
+		b.threshold, b.timeout = 1, 10*time.Millisecond
+		b.Do(fail)
+		time.Sleep(20 * time.Millisecond)
+
+		b.Do(fail)
+		if b.State() != OpenState {
+			t.Errorf("failed probe: %s != %s", b.State(), OpenState)
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		if err := b.Do(ok); err != nil {
+			t.Error(err)
+		}
+		if b.State() != ClosedState {
+			t.Errorf("%s != %s", b.State(), ClosedState)
+		}
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		b := NewBreaker("test", cfg.Get("breaker"), Context{})
+		b.threshold = 2
+		b.Do(fail)
+		b.Do(ok)
+		b.Do(fail)
+		if b.State() != ClosedState {
+			t.Errorf("%s != %s", b.State(), ClosedState)
+		}
+	})
+}
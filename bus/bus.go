@@ -1,8 +1,10 @@
 package bus
 
 import (
+	"context"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -12,8 +14,12 @@ import (
 
 	"github.com/mhanygin/broforce/config"
 	"github.com/mhanygin/broforce/logger"
+	"github.com/mhanygin/broforce/metrics"
+	"github.com/mhanygin/broforce/tracing"
 )
 
+const drainInterval = 100 * time.Millisecond
+
 var (
 	once        sync.Once
 	instance    *EventsBus
@@ -21,16 +27,31 @@ var (
 )
 
 type Context struct {
-	Func   Handler
-	Name   string
-	Log    *log.Entry
-	Config config.ConfigData
-	Bus    *EventsBus
+	Ctx     context.Context
+	Func    Handler
+	Name    string
+	Type    string
+	Log     *log.Entry
+	Config  config.ConfigData
+	Bus     *EventsBus
+	OrderBy KeyFunc
+	Retry   SafeParams
+	pool    *pool
+	order   *order
+	span    string
 }
 
+// SafeParams is retry policy: Retry retries after the first attempt
+// with Delay growing by Multiplier up to MaxDelay, randomized by
+// Jitter (fraction of delay). Retryable classifies errors, errors
+// wrapped by Permanent are never retried.
 type SafeParams struct {
-	Retry int
-	Delay time.Duration
+	Retry      int
+	Delay      time.Duration
+	MaxDelay   time.Duration
+	Multiplier float64
+	Jitter     float64
+	Retryable  func(err error) bool
 }
 
 type Task interface {
@@ -40,16 +61,25 @@ type Task interface {
 type Handler func(e Event, ctx Context) error
 
 type EventsBus struct {
+	lock      sync.Mutex
+	inbox     map[string]chan Event
+	start     sync.Once
+	ctx       context.Context
+	cancel    context.CancelFunc
+	inflight  int
+	published map[string]uint64
 }
 
 type adapter interface {
 	Run(cfg config.ConfigData) error
 	Publish(e Event) error
-	Subscribe(subject string, ctx Context)
+	Subscribe(subject string, ctx Context) Subscription
 }
 
 type adapterConfig struct {
 	Name       string
+	Priority   int
+	Running    bool
 	EventTypes []*regexp.Regexp
 	Adapter    adapter
 }
@@ -77,72 +107,287 @@ func GetNameAdapters() []string {
 	return result
 }
 
+// Named returns handler name unique for task instance: name itself when
+// task runs under its type name, `<instance>.<name>` otherwise.
+func (p Context) Named(name string) string {
+	if len(p.Type) == 0 || p.Name == p.Type {
+		return name
+	}
+	return fmt.Sprintf("%s.%s", p.Name, name)
+}
+
+// Publish marks event with ctx.Name as source and publishes it to the bus.
+// Inside handler event is linked to the handler span.
+func (p Context) Publish(e Event) error {
+	if len(e.Source()) == 0 {
+		e.SetHeader(SourceHeader, p.Name)
+	}
+	if len(p.span) != 0 {
+		e.SetHeader(SpanHeader, p.span)
+	}
+	return p.Bus.Publish(e)
+}
+
 func NewUUID() string {
 	return uuid.NewV4().String()
 }
 
 func SafeRun(r func(ctx Context) error, sp SafeParams) func(ctx Context) error {
 	return func(ctx Context) error {
-		for {
-			if err := r(ctx); err != nil {
+		if ctx.Ctx == nil {
+			ctx.Ctx = context.Background()
+		}
+		return sp.DoContext(ctx.Ctx, func(attempt int) error {
+			err := r(ctx)
+			if err != nil {
 				logger.Log.Error(err)
-				if sp.Retry <= 0 {
-					return err
-				} else {
-					sp.Retry--
-					time.Sleep(sp.Delay)
-				}
-			} else {
-				break
 			}
-		}
-		return nil
+			return err
+		})
 	}
 }
 
+func sortAdapters() {
+	sort.SliceStable(busAdapters, func(i, j int) bool {
+		return busAdapters[i].Priority > busAdapters[j].Priority
+	})
+}
+
 func New(cfg config.ConfigData) *EventsBus {
 	once.Do(func() {
 		for _, acfg := range busAdapters {
+			if !cfg.Exist(acfg.Name) {
+				continue
+			}
+			acfg.Priority = cfg.GetIntOr(fmt.Sprintf("%s.priority", acfg.Name), 0)
 			for _, et := range cfg.GetArrayString(fmt.Sprintf("%s.event-types", acfg.Name)) {
 				if r, err := regexp.Compile(et); err == nil {
 					acfg.EventTypes = append(acfg.EventTypes, r)
 				} else {
-					logger.Log.Errorf("Error: event type %s for adapter % not compile", et, acfg.Name)
+					logger.Log.Errorf("Error: event type %s for adapter %s not compile", et, acfg.Name)
 				}
 			}
 			if err := acfg.Adapter.Run(cfg.Get(acfg.Name)); err != nil {
 				logger.Log.Errorf("Error: %v", err)
+			} else {
+				acfg.Running = true
+			}
+		}
+		sortAdapters()
+		instance = &EventsBus{}
+		if cfg.Exist("dead-letter") {
+			if store, err := NewDeadLetterStore(cfg.Get("dead-letter")); err != nil {
+				logger.Log.Errorf("Error: %v", err)
+			} else {
+				setDeadLetters(store)
+				go store.watch(instance.context(), instance, time.Duration(cfg.GetIntOr("dead-letter.interval", defaultDeadLetterInterval))*time.Second)
+			}
+		}
+		if cfg.Exist("event-store") {
+			if store, err := NewEventStore(cfg.Get("event-store")); err != nil {
+				logger.Log.Errorf("Error: %v", err)
+			} else {
+				events = store
 			}
 		}
+	})
+	return instance
+}
+
+// NewLocal creates in-memory bus for one-shot commands: all events are
+// delivered by simple adapter, dead-letter and event stores are not
+// used. Only the first of New and NewLocal takes effect.
+func NewLocal() *EventsBus {
+	once.Do(func() {
+		for _, acfg := range busAdapters {
+			if acfg.Name != "simple" {
+				continue
+			}
+			acfg.EventTypes = []*regexp.Regexp{regexp.MustCompile(".*")}
+			if err := acfg.Adapter.Run(nil); err != nil {
+				logger.Log.Errorf("Error: %v", err)
+			} else {
+				acfg.Running = true
+			}
+		}
+		sortAdapters()
 		instance = &EventsBus{}
 	})
 	return instance
 }
 
-func (p *EventsBus) Publish(e Event) error {
-	for _, acfg := range busAdapters {
-		for _, et := range acfg.EventTypes {
-			if len(et.FindAllString(e.Subject, -1)) == 1 {
-				return acfg.Adapter.Publish(e)
+// match reports whether event types of adapter match subject, its
+// hierarchical form or its flat alias.
+func (p *adapterConfig) match(subject string) bool {
+	for _, et := range p.EventTypes {
+		for _, s := range []string{subject, Canonical(subject), Flat(subject)} {
+			if len(et.FindAllString(s, -1)) == 1 {
+				return true
 			}
 		}
 	}
+	return false
+}
+
+// Publish fans out event to every adapter whose event types match
+// subject, in order of descending adapter priority. Errors of all
+// adapters are returned together.
+func (p *EventsBus) Publish(e Event) (err error) {
+	if len(e.ID()) == 0 {
+		e.SetHeader(IDHeader, NewUUID())
+		e.SetHeader(TimestampHeader, time.Now().Format(time.RFC3339Nano))
+	}
+	if isInbox(e.Subject) {
+		return p.reply(e)
+	}
+	span := tracing.Start(e.Trace, e.Header(SpanHeader), fmt.Sprintf("%s publish", Canonical(e.Subject)), tracing.ProducerKind)
+	span.SetAttribute("messaging.destination", e.Subject)
+	span.SetAttribute("messaging.message_id", e.ID())
+	defer func() { span.End(err) }()
+	e.SetHeader(SpanHeader, span.ID())
+	if events != nil {
+		if err := events.Append(e); err != nil {
+			logger.Log.Errorf("event store: %v", err)
+		}
+	}
+	p.count(e.Subject)
+	metrics.Published(Canonical(e.Subject))
+	errs := make(Errors, 0)
+	found := false
+	for _, acfg := range busAdapters {
+		if !acfg.match(e.Subject) {
+			continue
+		}
+		found = true
+		if err := acfg.Adapter.Publish(e); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", acfg.Name, err))
+		}
+	}
+	if !found {
+		return fmt.Errorf("no adapter for %s", e.Subject)
+	}
+	if len(errs) != 0 {
+		return errs
+	}
 	return nil
 }
 
-func (p *EventsBus) Subscribe(subject string, ctx Context) {
+// Subscribe accepts flat subjects from const.go and hierarchical ones
+// with wildcards (`git.>`, `slack.*`). Subscription is made on every
+// adapter matching subject, so handler is called once per adapter the
+// event was published to. Wildcard subscriptions are made on every
+// configured adapter, since any of them may carry matching events.
+// Handlers get ctx.Ctx cancelled when Shutdown deadline expires.
+// When ctx.OrderBy is set, events with the same key are delivered
+// sequentially, events with different keys in parallel. Failed handler
+// is retried by ctx.Retry, overridden by `retry` section of ctx.Config.
+// Event with HandlerHeader is delivered only to handler of that name.
+func (p *EventsBus) Subscribe(subject string, ctx Context) Subscription {
+	h := ctx.Func
+	ctx.Func = func(e Event, ctx Context) error {
+		if to := e.Header(HandlerHeader); len(to) != 0 && to != ctx.Name {
+			return nil
+		}
+		ctx.Log = logger.Logger4Handler(ctx.Name, e.Trace)
+		span := tracing.Start(e.Trace, e.Header(SpanHeader), fmt.Sprintf("%s process", Canonical(e.Subject)), tracing.ConsumerKind)
+		span.SetAttribute("messaging.destination", e.Subject)
+		span.SetAttribute("messaging.message_id", e.ID())
+		span.SetAttribute("handler", ctx.Name)
+		span.SetAttribute("attempt", fmt.Sprintf("%d", e.Attempt()))
+		ctx.span = span.ID()
+		start := time.Now()
+		err := h(e, ctx)
+		span.End(err)
+		ctx.Log.Debugf("func: %s, work time: %s", ctx.Name, time.Since(start))
+		metrics.Handled(Canonical(e.Subject), ctx.Name, time.Since(start), err)
+		return err
+	}
+	if ctx.Log == nil {
+		ctx.Log = logger.Logger4Handler(ctx.Name, "")
+	}
+	if ctx.Ctx == nil {
+		ctx.Ctx = p.context()
+	}
+	ctx.Bus = p
+	if ctx.Config != nil && ctx.Config.Exist("retry") {
+		ctx.Retry = NewSafeParams(ctx.Config.Get("retry"), ctx.Retry)
+	}
+	ctx.pool = newPool(ctx.Config, ctx.Name)
+	ctx.order = newOrder(ctx.OrderBy, ctx.pool)
+	subs := make(subscriptions, 0)
 	for _, acfg := range busAdapters {
-		for _, et := range acfg.EventTypes {
-			if len(et.FindAllString(subject, -1)) == 1 {
-				ctx.Func = func(e Event, ctx Context) error {
-					ctx.Log = logger.Logger4Handler(ctx.Name, e.Trace)
-					defer ctx.Log.Debugf("func: %s, work time: %s", ctx.Name, time.Since(time.Now()))
-					return ctx.Func(e, ctx)
-				}
-				if ctx.Log == nil {
-					ctx.Log = logger.Logger4Handler(ctx.Name, "")
-				}
-				acfg.Adapter.Subscribe(subject, ctx)
+		if (isWildcard(subject) && len(acfg.EventTypes) != 0) || acfg.match(subject) {
+			subs = append(subs, acfg.Adapter.Subscribe(subject, ctx))
+		}
+	}
+	if ctx.pool != nil {
+		subs = append(subs, newSubscription(ctx.pool.stop))
+	}
+	return subs
+}
+
+func (p *EventsBus) context() context.Context {
+	p.start.Do(func() {
+		p.ctx, p.cancel = context.WithCancel(context.Background())
+	})
+	return p.ctx
+}
+
+func (p *EventsBus) begin() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.inflight++
+}
+
+func (p *EventsBus) end() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.inflight--
+}
+
+// InFlight returns number of deliveries queued or being handled.
+func (p *EventsBus) InFlight() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.inflight
+}
+
+// Shutdown waits for in-flight deliveries, including events they
+// publish, until ctx is done; then contexts of handlers are cancelled
+// and adapters are closed. Sensors must be stopped before, otherwise
+// drain may never end.
+func (p *EventsBus) Shutdown(ctx context.Context) error {
+	p.context()
+	defer p.closeAdapters()
+	defer p.cancel()
+
+	for {
+		n := p.InFlight()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("bus: %d deliveries not finished: %v", n, ctx.Err())
+		case <-time.After(drainInterval):
+		}
+	}
+}
+
+type closer interface {
+	close() error
+}
+
+func (p *EventsBus) closeAdapters() {
+	if events != nil {
+		if err := events.Close(); err != nil {
+			logger.Log.Errorf("event store: %v", err)
+		}
+	}
+	for _, acfg := range busAdapters {
+		if c, ok := acfg.Adapter.(closer); ok && acfg.Running {
+			if err := c.close(); err != nil {
+				logger.Log.Errorf("Error: %s: %v", acfg.Name, err)
 			}
 		}
 	}
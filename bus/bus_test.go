@@ -1,15 +1,45 @@
 package bus
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/mhanygin/broforce/config"
 	"github.com/mhanygin/broforce/logger"
 )
 
+type testAdapter struct {
+	name   string
+	err    error
+	order  *[]string
+	lock   *sync.Mutex
+	events []Event
+}
+
+func (p *testAdapter) Run(cfg config.ConfigData) error {
+	return nil
+}
+
+func (p *testAdapter) Publish(e Event) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	*p.order = append(*p.order, p.name)
+	p.events = append(p.events, e)
+	return p.err
+}
+
+func (p *testAdapter) Subscribe(subject string, ctx Context) Subscription {
+	return newSubscription(func() {})
+}
+
 func TestEventsBus(t *testing.T) {
 	tmpfile, err := ioutil.TempFile("/tmp", "config_")
 	if err != nil {
@@ -36,4 +66,87 @@ func TestEventsBus(t *testing.T) {
 			t.Fail()
 		}
 	})
+	t.Run("Publish", func(t *testing.T) {
+		saved := busAdapters
+		defer func() { busAdapters = saved }()
+
+		lock := sync.Mutex{}
+		order := make([]string, 0)
+		first := &testAdapter{name: "first", order: &order, lock: &lock}
+		second := &testAdapter{name: "second", order: &order, lock: &lock, err: fmt.Errorf("second failed")}
+		busAdapters = []*adapterConfig{
+			&adapterConfig{
+				Name:       "first",
+				Priority:   1,
+				EventTypes: []*regexp.Regexp{regexp.MustCompile("^SERVE$")},
+				Adapter:    first},
+			&adapterConfig{
+				Name:       "second",
+				Priority:   2,
+				EventTypes: []*regexp.Regexp{regexp.MustCompile("^(SERVE|OUTDATED)$")},
+				Adapter:    second}}
+		sortAdapters()
+
+		b := &EventsBus{}
+		err := b.Publish(Event{Subject: ServeCmdEvent, Coding: JsonCoding})
+		if err == nil || !strings.Contains(err.Error(), "second failed") {
+			t.Errorf("error not aggregated: %v", err)
+		}
+		if len(first.events) != 1 || len(second.events) != 1 {
+			t.Errorf("fan out: first %d, second %d", len(first.events), len(second.events))
+		}
+		if strings.Join(order, ",") != "second,first" {
+			t.Errorf("order %v != [second first]", order)
+		}
+
+		second.err = nil
+		if err := b.Publish(Event{Subject: OutdatedEvent, Coding: JsonCoding}); err != nil {
+			t.Error(err)
+		}
+		if len(first.events) != 1 || len(second.events) != 2 {
+			t.Errorf("routing: first %d, second %d", len(first.events), len(second.events))
+		}
+
+		if err := b.Publish(Event{Subject: TimerEvent, Coding: JsonCoding}); err == nil {
+			t.Errorf("no error for %s without adapter", TimerEvent)
+		}
+		if n := b.Published()[Canonical(ServeCmdEvent)]; n != 1 {
+			t.Errorf("published %s %d != 1", ServeCmdEvent, n)
+		}
+	})
+	t.Run("Named", func(t *testing.T) {
+		if n := (Context{Name: "serve", Type: "serve"}).Named("ServeHandler"); n != "ServeHandler" {
+			t.Errorf("%s != ServeHandler", n)
+		}
+		if n := (Context{Name: "serve-fast", Type: "serve"}).Named("ServeHandler"); n != "serve-fast.ServeHandler" {
+			t.Errorf("%s != serve-fast.ServeHandler", n)
+		}
+	})
+	t.Run("Shutdown", func(t *testing.T) {
+		b := &EventsBus{}
+		release := make(chan struct{})
+		ctx := Context{Bus: b}
+		for i := 0; i < 2; i++ {
+			dispatch(Event{Subject: ServeCmdEvent}, ctx, func() { <-release })
+		}
+		if b.InFlight() != 2 {
+			t.Errorf("in flight %d != 2", b.InFlight())
+		}
+
+		expired, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := b.Shutdown(expired); err == nil {
+			t.Error("no error on expired deadline")
+		}
+		if b.context().Err() == nil {
+			t.Error("handlers context not cancelled")
+		}
+
+		close(release)
+		drain, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := b.Shutdown(drain); err != nil {
+			t.Error(err)
+		}
+	})
 }
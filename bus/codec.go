@@ -0,0 +1,125 @@
+package bus
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ghodss/yaml"
+	"github.com/vmihailenco/msgpack"
+)
+
+var codecs = make(map[string]Codec)
+
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+func init() {
+	RegisterCodec(JsonCoding, Codec(&jsonCodec{}))
+	RegisterCodec(MsgpackCoding, Codec(&msgpackCodec{}))
+	RegisterCodec(GobCoding, Codec(&gobCodec{}))
+	RegisterCodec(YamlCoding, Codec(&yamlCodec{}))
+	RegisterCodec(RawCoding, Codec(&rawCodec{}))
+}
+
+func RegisterCodec(coding string, codec Codec) {
+	codecs[coding] = codec
+}
+
+func GetCodec(coding string) (Codec, error) {
+	if c, ok := codecs[coding]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("unknown coding `%s`", coding)
+}
+
+type jsonCodec struct {
+}
+
+func (p *jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (p *jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type msgpackCodec struct {
+}
+
+func (p *msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (p *msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+type gobCodec struct {
+}
+
+func (p *gobCodec) Marshal(v interface{}) ([]byte, error) {
+	buffer := bytes.NewBuffer(make([]byte, 0))
+	if err := gob.NewEncoder(buffer).Encode(v); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (p *gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type yamlCodec struct {
+}
+
+func (p *yamlCodec) Marshal(v interface{}) ([]byte, error) {
+	return yaml.Marshal(v)
+}
+
+func (p *yamlCodec) Unmarshal(data []byte, v interface{}) error {
+	return yaml.Unmarshal(data, v)
+}
+
+type rawMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+type rawUnmarshaler interface {
+	Unmarshal(data []byte) error
+}
+
+// rawCodec passes bytes as is. Values with Marshal/Unmarshal methods,
+// such as generated protobuf messages, encode themselves.
+type rawCodec struct {
+}
+
+func (p *rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch d := v.(type) {
+	case []byte:
+		return d, nil
+	case string:
+		return []byte(d), nil
+	case rawMarshaler:
+		return d.Marshal()
+	default:
+		return nil, fmt.Errorf("raw coding: unsupported type %T", v)
+	}
+}
+
+func (p *rawCodec) Unmarshal(data []byte, v interface{}) error {
+	switch d := v.(type) {
+	case *[]byte:
+		*d = append(make([]byte, 0, len(data)), data...)
+	case *string:
+		*d = string(data)
+	case rawUnmarshaler:
+		return d.Unmarshal(data)
+	default:
+		return fmt.Errorf("raw coding: unsupported type %T", v)
+	}
+	return nil
+}
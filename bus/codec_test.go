@@ -0,0 +1,84 @@
+package bus
+
+import (
+	"reflect"
+	"testing"
+)
+
+type rawMessage struct {
+	value string
+}
+
+func (p *rawMessage) Marshal() ([]byte, error) {
+	return []byte(p.value), nil
+}
+
+func (p *rawMessage) Unmarshal(data []byte) error {
+	p.value = string(data)
+	return nil
+}
+
+func TestCodec(t *testing.T) {
+	type data struct {
+		Param1 int               `json:"param1"`
+		Param2 []string          `json:"param2"`
+		Param3 map[string]string `json:"param3"`
+	}
+
+	in := data{
+		Param1: 1,
+		Param2: []string{"val1", "val2"},
+		Param3: map[string]string{"pp3": "val1"}}
+
+	for _, coding := range []string{JsonCoding, MsgpackCoding, GobCoding, YamlCoding} {
+		t.Run(coding, func(t *testing.T) {
+			e := Event{Subject: UnknownEvent, Coding: coding}
+			if err := e.Marshal(in); err != nil {
+				t.Fatal(err)
+			}
+			out := data{}
+			if err := e.Unmarshal(&out); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(in, out) {
+				t.Errorf("%v != %v", out, in)
+			}
+		})
+	}
+
+	t.Run(RawCoding, func(t *testing.T) {
+		e := Event{Subject: UnknownEvent, Coding: RawCoding}
+		if err := e.Marshal(&rawMessage{value: "message"}); err != nil {
+			t.Fatal(err)
+		}
+		out := rawMessage{}
+		if err := e.Unmarshal(&out); err != nil {
+			t.Fatal(err)
+		}
+		if out.value != "message" {
+			t.Errorf("%s != message", out.value)
+		}
+
+		if err := e.Marshal([]byte("bytes")); err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 0)
+		if err := e.Unmarshal(&b); err != nil || string(b) != "bytes" {
+			t.Errorf("%s != bytes: %v", string(b), err)
+		}
+
+		if err := e.Marshal(in); err == nil {
+			t.Error("struct marshaled with raw coding")
+		}
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		e := Event{Subject: UnknownEvent, Coding: "xml"}
+		if err := e.Marshal(in); err == nil {
+			t.Error("marshal with unknown coding")
+		}
+		if err := e.Unmarshal(&data{}); err == nil {
+			t.Error("unmarshal with unknown coding")
+		}
+	})
+}
@@ -12,8 +12,27 @@ const (
 	TelegramMsgEvent      = "TELEGRAM_MESSAGE"
 	JiraHookEvent         = "JIRA"
 	UnknownEvent          = "UNKNOWN"
+	CircuitEvent          = "CIRCUIT"
+	InboxEvent            = "_INBOX"
 )
 
 const (
-	JsonCoding = "json"
+	JsonCoding    = "json"
+	MsgpackCoding = "msgpack"
+	GobCoding     = "gob"
+	YamlCoding    = "yaml"
+	RawCoding     = "raw"
+)
+
+const (
+	IDHeader        = "id"
+	TimestampHeader = "timestamp"
+	SourceHeader    = "source"
+	CausationHeader = "causation-id"
+	AttemptHeader   = "attempt"
+	DeliveryHeader  = "delivery-id"
+	OrderKeyHeader  = "order-key"
+	SpanHeader      = "span-id"
+	ReplayHeader    = "replay-of"
+	HandlerHeader   = "handler"
 )
@@ -0,0 +1,222 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+)
+
+//config section
+//
+//bus:
+//  dead-letter:
+//    path: /var/lib/broforce/dead-letter
+//    interval: 10
+//
+
+const (
+	defaultDeadLetterPath     = "dead-letter"
+	defaultDeadLetterInterval = 10
+	deadLetterExt             = ".json"
+)
+
+var (
+	deadLettersLock sync.Mutex
+	deadLetters     *DeadLetterStore
+)
+
+// setDeadLetters sets store of events failed by SafeHandler, nil
+// disables it.
+func setDeadLetters(store *DeadLetterStore) {
+	deadLettersLock.Lock()
+	defer deadLettersLock.Unlock()
+	deadLetters = store
+}
+
+func getDeadLetters() *DeadLetterStore {
+	deadLettersLock.Lock()
+	defer deadLettersLock.Unlock()
+	return deadLetters
+}
+
+type DeadLetter struct {
+	ID           string    `json:"id"`
+	Handler      string    `json:"handler"`
+	Event        Event     `json:"event"`
+	Error        string    `json:"error"`
+	Attempts     int       `json:"attempts"`
+	FirstAttempt time.Time `json:"first-attempt"`
+	LastAttempt  time.Time `json:"last-attempt"`
+	Requeued     bool      `json:"requeued"`
+}
+
+// DeadLetterStore keeps events whose handlers exhausted SafeHandler
+// retries, one json file per event.
+type DeadLetterStore struct {
+	path string
+	lock sync.Mutex
+}
+
+func NewDeadLetterStore(cfg config.ConfigData) (*DeadLetterStore, error) {
+	return newDeadLetterStore(cfg.GetStringOr("path", defaultDeadLetterPath))
+}
+
+func newDeadLetterStore(path string) (*DeadLetterStore, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	return &DeadLetterStore{path: path}, nil
+}
+
+func (p *DeadLetterStore) file(id string) string {
+	return filepath.Join(p.path, id+deadLetterExt)
+}
+
+func (p *DeadLetterStore) Put(dl DeadLetter) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	data, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	tmp := p.file(dl.ID) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.file(dl.ID))
+}
+
+func (p *DeadLetterStore) Get(id string) (DeadLetter, error) {
+	dl := DeadLetter{}
+	data, err := ioutil.ReadFile(p.file(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return dl, fmt.Errorf("dead letter %s not found", id)
+		}
+		return dl, err
+	}
+	return dl, json.Unmarshal(data, &dl)
+}
+
+func (p *DeadLetterStore) List() ([]DeadLetter, error) {
+	out := make([]DeadLetter, 0)
+	files, err := ioutil.ReadDir(p.path)
+	if err != nil {
+		return out, err
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), deadLetterExt) {
+			continue
+		}
+		dl, err := p.Get(strings.TrimSuffix(f.Name(), deadLetterExt))
+		if err != nil {
+			return out, err
+		}
+		out = append(out, dl)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].LastAttempt.Before(out[j].LastAttempt)
+	})
+	return out, nil
+}
+
+func (p *DeadLetterStore) Delete(id string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return os.Remove(p.file(id))
+}
+
+// Requeue marks the dead letter for re-publish, the running instance
+// publishes it on the next store scan.
+func (p *DeadLetterStore) Requeue(id string) error {
+	dl, err := p.Get(id)
+	if err != nil {
+		return err
+	}
+	dl.Requeued = true
+	return p.Put(dl)
+}
+
+func (p *DeadLetterStore) republish(b *EventsBus) {
+	list, err := p.List()
+	if err != nil {
+		logger.Log.Errorf("dead letter: %v", err)
+		return
+	}
+	for _, dl := range list {
+		if !dl.Requeued {
+			continue
+		}
+		// only the failed handler gets it, others have handled it already
+		dl.Event.SetHeader(HandlerHeader, dl.Handler)
+		if err := b.Publish(dl.Event); err != nil {
+			logger.Log.Errorf("dead letter: republish %s: %v", dl.ID, err)
+			continue
+		}
+		logger.Log.Infof("dead letter: republish %s %s", dl.ID, dl.Event.Subject)
+		if err := p.Delete(dl.ID); err != nil {
+			logger.Log.Errorf("dead letter: %v", err)
+		}
+	}
+}
+
+func (p *DeadLetterStore) watch(ctx context.Context, b *EventsBus, interval time.Duration) {
+	for {
+		p.republish(b)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// deadLetteredError is error of handler whose event is kept in the
+// dead-letter store, delivery of such event is over.
+type deadLetteredError struct {
+	err error
+}
+
+func (p deadLetteredError) Error() string {
+	return p.err.Error()
+}
+
+func IsDeadLettered(err error) bool {
+	_, ok := err.(deadLetteredError)
+	return ok
+}
+
+// deadLetter keeps failed event and returns err marked as dead-lettered
+// when the event is stored.
+func deadLetter(e Event, ctx Context, err error, attempts int, first, last time.Time) error {
+	store := getDeadLetters()
+	if store == nil {
+		return err
+	}
+	dl := DeadLetter{
+		ID:           NewUUID(),
+		Handler:      ctx.Name,
+		Event:        e,
+		Error:        err.Error(),
+		Attempts:     attempts,
+		FirstAttempt: first,
+		LastAttempt:  last}
+	if err := store.Put(dl); err != nil {
+		ctx.Log.Errorf("dead letter: %v", err)
+		return err
+	}
+	ctx.Log.Errorf("dead letter: %s, attempts: %d", dl.ID, attempts)
+	return deadLetteredError{err: err}
+}
@@ -0,0 +1,145 @@
+package bus
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+)
+
+func TestDeadLetter(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("/tmp", "config_")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	cfg := config.New(tmpfile.Name(), config.YAMLAdapter)
+	logger.New(cfg.Get("logger"))
+
+	dir, err := ioutil.TempDir("/tmp", "dead_letter_")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := newDeadLetterStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setDeadLetters(store)
+	defer setDeadLetters(nil)
+
+	t.Run("SafeHandler", func(t *testing.T) {
+		f := func(e Event, ctx Context) error {
+			return fmt.Errorf("serve failed")
+		}
+		err := SafeHandler(f, SafeParams{Retry: 2, Delay: 1})(
+			Event{Trace: "trace", Subject: ServeCmdEvent, Coding: JsonCoding},
+			Context{Name: "ServeHandler", Log: logger.Logger4Handler("ServeHandler", "")})
+		if !IsDeadLettered(err) {
+			t.Errorf("error not dead-lettered: %v", err)
+		}
+
+		list, err := store.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 {
+			t.Fatalf("len %d != 1", len(list))
+		}
+		dl := list[0]
+		if dl.Handler != "ServeHandler" || dl.Attempts != 3 || dl.Event.Subject != ServeCmdEvent {
+			t.Errorf("unexpected dead letter %+v", dl)
+		}
+		if strings.Compare(dl.Error, "serve failed") != 0 {
+			t.Errorf("%s != serve failed", dl.Error)
+		}
+		if dl.LastAttempt.Before(dl.FirstAttempt) {
+			t.Errorf("last attempt %v before first %v", dl.LastAttempt, dl.FirstAttempt)
+		}
+	})
+
+	t.Run("Requeue", func(t *testing.T) {
+		list, _ := store.List()
+		if len(list) == 0 {
+			t.Fatal("store empty")
+		}
+		id := list[0].ID
+		if err := store.Requeue(id); err != nil {
+			t.Fatal(err)
+		}
+		if dl, err := store.Get(id); err != nil || !dl.Requeued {
+			t.Errorf("not requeued: %v", err)
+		}
+		if err := store.Delete(id); err != nil {
+			t.Error(err)
+		}
+		if _, err := store.Get(id); err == nil {
+			t.Error("dead letter not deleted")
+		}
+	})
+	t.Run("Republish", func(t *testing.T) {
+		saved := busAdapters
+		defer func() { busAdapters = saved }()
+		simple := &simpleAdapter{}
+		simple.Run(cfg.Get("simple"))
+		busAdapters = []*adapterConfig{&adapterConfig{
+			Name:       "simple",
+			EventTypes: []*regexp.Regexp{regexp.MustCompile("^(SERVE|OUTDATED)$")},
+			Adapter:    simple}}
+
+		lock := sync.Mutex{}
+		got := make(map[string]int)
+		derived := make([]Event, 0)
+		b := &EventsBus{}
+		for _, name := range []string{"ServeHandler", "OtherHandler"} {
+			name := name
+			b.Subscribe(ServeCmdEvent, Context{Name: name, Func: func(e Event, ctx Context) error {
+				lock.Lock()
+				got[name]++
+				lock.Unlock()
+				return ctx.Publish(*e.Derive(OutdatedEvent, JsonCoding))
+			}})
+		}
+		b.Subscribe(OutdatedEvent, Context{Name: "OutdatedHandler", Func: func(e Event, ctx Context) error {
+			lock.Lock()
+			defer lock.Unlock()
+			got["OutdatedHandler"]++
+			derived = append(derived, e)
+			return nil
+		}})
+
+		dl := DeadLetter{ID: "republish", Handler: "ServeHandler", Requeued: true,
+			Event: Event{Trace: "trace", Subject: ServeCmdEvent, Coding: JsonCoding, Data: []byte(`{}`)}}
+		if err := store.Put(dl); err != nil {
+			t.Fatal(err)
+		}
+		store.republish(b)
+		time.Sleep(100 * time.Millisecond)
+
+		lock.Lock()
+		defer lock.Unlock()
+		if got["ServeHandler"] != 1 || got["OtherHandler"] != 0 || got["OutdatedHandler"] != 1 {
+			t.Errorf("delivered %v", got)
+		}
+		for _, e := range derived {
+			if len(e.Header(HandlerHeader)) != 0 || len(e.Header(ReplayHeader)) != 0 {
+				t.Errorf("delivery headers propagated %v", e.Headers)
+			}
+		}
+		if _, err := store.Get(dl.ID); err == nil {
+			t.Error("republished dead letter not deleted")
+		}
+	})
+}
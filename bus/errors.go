@@ -0,0 +1,15 @@
+package bus
+
+import (
+	"strings"
+)
+
+type Errors []error
+
+func (p Errors) Error() string {
+	msgs := make([]string, 0)
+	for _, err := range p {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
@@ -1,8 +1,9 @@
 package bus
 
 import (
-	"encoding/json"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Event struct {
@@ -10,44 +11,114 @@ type Event struct {
 	Subject string
 	Coding  string
 	Data    []byte
+	ReplyTo string
+	Headers map[string]string
 }
 
 func NewEvent(trace string, subject string, coding string) *Event {
 	return &Event{Trace: trace,
 		Subject: subject,
 		Coding:  coding,
-		Data:    make([]byte, 0)}
+		Data:    make([]byte, 0),
+		Headers: newHeaders()}
 }
 
 func NewEventWithData(trace string, subject string, coding string, data interface{}) (*Event, error) {
 	event := Event{Trace: trace,
 		Subject: subject,
 		Coding:  coding,
-		Data:    make([]byte, 0)}
+		Data:    make([]byte, 0),
+		Headers: newHeaders()}
 	return &event, event.Marshal(data)
 }
 
-func (p *Event) Marshal(d interface{}) error {
-	var err error
-	switch p.Coding {
-	case JsonCoding:
-		if p.Data, err = json.Marshal(d); err != nil {
-			return err
+func newHeaders() map[string]string {
+	return map[string]string{
+		IDHeader:        NewUUID(),
+		TimestampHeader: time.Now().Format(time.RFC3339Nano)}
+}
+
+// Derive creates event caused by p: trace and headers are propagated,
+// causation-id refers to p. Headers of delivery of p (attempt, handler,
+// replay-of) are not propagated.
+func (p *Event) Derive(subject string, coding string) *Event {
+	event := NewEvent(p.Trace, subject, coding)
+	for k, v := range p.Headers {
+		switch k {
+		case IDHeader, TimestampHeader, SourceHeader, AttemptHeader, HandlerHeader, ReplayHeader:
+			continue
 		}
+		event.Headers[k] = v
+	}
+	if id := p.ID(); len(id) != 0 {
+		event.Headers[CausationHeader] = id
+	}
+	return event
+}
+
+func (p *Event) DeriveWithData(subject string, coding string, data interface{}) (*Event, error) {
+	event := p.Derive(subject, coding)
+	return event, event.Marshal(data)
+}
+
+func (p *Event) Marshal(d interface{}) error {
+	codec, err := GetCodec(p.Coding)
+	if err != nil {
+		return err
+	}
+	data, err := codec.Marshal(d)
+	if err != nil {
+		return err
 	}
+	p.Data = data
 	return nil
 }
 
 func (p *Event) Unmarshal(v interface{}) error {
-	switch p.Coding {
-	case JsonCoding:
-		if err := json.Unmarshal(p.Data, v); err != nil {
-			return err
-		}
+	codec, err := GetCodec(p.Coding)
+	if err != nil {
+		return err
 	}
-	return nil
+	return codec.Unmarshal(p.Data, v)
 }
 
 func (p *Event) SubjectIs(subject string) bool {
-	return strings.Compare(p.Subject, subject) == 0
+	return strings.Compare(Canonical(p.Subject), Canonical(subject)) == 0
+}
+
+func (p *Event) Header(key string) string {
+	return p.Headers[key]
+}
+
+// SetHeader copies headers before change, so events sharing
+// headers with p are not affected.
+func (p *Event) SetHeader(key string, value string) {
+	headers := make(map[string]string, len(p.Headers)+1)
+	for k, v := range p.Headers {
+		headers[k] = v
+	}
+	headers[key] = value
+	p.Headers = headers
+}
+
+func (p *Event) ID() string {
+	return p.Header(IDHeader)
+}
+
+func (p *Event) Source() string {
+	return p.Header(SourceHeader)
+}
+
+func (p *Event) CausationID() string {
+	return p.Header(CausationHeader)
+}
+
+func (p *Event) Timestamp() time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, p.Header(TimestampHeader))
+	return t
+}
+
+func (p *Event) Attempt() int {
+	a, _ := strconv.Atoi(p.Header(AttemptHeader))
+	return a
 }
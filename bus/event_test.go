@@ -57,4 +57,35 @@ func TestEvent(t *testing.T) {
 			t.Fail()
 		}
 	})
+	t.Run("Headers", func(t *testing.T) {
+		e := NewEvent("trace", GitlabHookEvent, JsonCoding)
+		if len(e.ID()) == 0 {
+			t.Error("id is empty")
+		}
+		if e.Timestamp().IsZero() {
+			t.Error("timestamp is empty")
+		}
+		e.Headers[DeliveryHeader] = "delivery"
+		e.Headers[SourceHeader] = "hookSensor"
+
+		d, err := e.DeriveWithData(ServeCmdEvent, JsonCoding, data{Param1: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Trace != "trace" || d.ID() == e.ID() || d.CausationID() != e.ID() {
+			t.Errorf("trace %s, id %s, causation %s", d.Trace, d.ID(), d.CausationID())
+		}
+		if d.Header(DeliveryHeader) != "delivery" {
+			t.Errorf("%s != delivery", d.Header(DeliveryHeader))
+		}
+		if len(d.Source()) != 0 {
+			t.Errorf("source %s propagated", d.Source())
+		}
+
+		c := *d
+		c.SetHeader(AttemptHeader, "2")
+		if c.Attempt() != 2 || d.Attempt() != 0 {
+			t.Errorf("attempt %d, original attempt %d", c.Attempt(), d.Attempt())
+		}
+	})
 }
@@ -0,0 +1,85 @@
+package bus
+
+import (
+	"sort"
+)
+
+type AdapterInfo struct {
+	Name          string              `json:"name"`
+	Priority      int                 `json:"priority"`
+	Running       bool                `json:"running"`
+	EventTypes    []string            `json:"event-types"`
+	Subscriptions map[string][]string `json:"subscriptions"`
+}
+
+// inspector is implemented by adapters able to list their
+// subscriptions: handler names by subject.
+type inspector interface {
+	subscriptions() map[string][]string
+}
+
+// GetAdapters describes adapters in order of descending priority.
+func GetAdapters() []AdapterInfo {
+	result := make([]AdapterInfo, 0, len(busAdapters))
+	for _, acfg := range busAdapters {
+		info := AdapterInfo{
+			Name:          acfg.Name,
+			Priority:      acfg.Priority,
+			Running:       acfg.Running,
+			EventTypes:    make([]string, 0, len(acfg.EventTypes)),
+			Subscriptions: make(map[string][]string)}
+		for _, et := range acfg.EventTypes {
+			info.EventTypes = append(info.EventTypes, et.String())
+		}
+		if i, ok := acfg.Adapter.(inspector); ok && acfg.Running {
+			info.Subscriptions = i.subscriptions()
+		}
+		result = append(result, info)
+	}
+	return result
+}
+
+func subscriptionNames(subs map[string]map[uint64]Context) map[string][]string {
+	result := make(map[string][]string, len(subs))
+	for subject, s := range subs {
+		names := make([]string, 0, len(s))
+		for _, ctx := range s {
+			names = append(names, ctx.Name)
+		}
+		sort.Strings(names)
+		result[subject] = names
+	}
+	return result
+}
+
+func (p *simpleAdapter) subscriptions() map[string][]string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return subscriptionNames(p.subs)
+}
+
+func (p *walAdapter) subscriptions() map[string][]string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return subscriptionNames(p.subs)
+}
+
+func (p *EventsBus) count(subject string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.published == nil {
+		p.published = make(map[string]uint64)
+	}
+	p.published[Canonical(subject)]++
+}
+
+// Published returns number of events published by subject.
+func (p *EventsBus) Published() map[string]uint64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	result := make(map[string]uint64, len(p.published))
+	for k, v := range p.published {
+		result[k] = v
+	}
+	return result
+}
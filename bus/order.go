@@ -0,0 +1,174 @@
+package bus
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/mhanygin/broforce/logger"
+)
+
+// KeyFunc extracts ordering key of event, see Context.OrderBy.
+type KeyFunc func(e Event) string
+
+func ByTrace(e Event) string {
+	return e.Trace
+}
+
+func ByHeader(key string) KeyFunc {
+	return func(e Event) string {
+		return e.Header(key)
+	}
+}
+
+// order delivers events with the same key one by one in order of
+// publishing, events with different keys are delivered in parallel.
+// Events with empty key are not ordered. Events waiting for their key
+// are counted against the pool queue and the pool policy applies to them.
+type order struct {
+	key     KeyFunc
+	pool    *pool
+	lock    sync.Mutex
+	cond    *sync.Cond
+	pending map[string][]ordered
+	queued  int
+	seq     uint64
+}
+
+type ordered struct {
+	j   job
+	seq uint64
+}
+
+func newOrder(key KeyFunc, pool *pool) *order {
+	if key == nil {
+		return nil
+	}
+	p := &order{key: key, pool: pool, pending: make(map[string][]ordered)}
+	p.cond = sync.NewCond(&p.lock)
+	return p
+}
+
+func (p *order) dispatch(j job, ctx Context) error {
+	key := p.key(j.e)
+	if len(key) == 0 {
+		return ctx.pool.dispatch(j)
+	}
+
+	p.lock.Lock()
+	for {
+		q, ok := p.pending[key]
+		if !ok {
+			break
+		}
+		if p.pool == nil || p.queued < cap(p.pool.queue) {
+			p.seq++
+			p.pending[key] = append(q, ordered{j: j, seq: p.seq})
+			p.queued++
+			p.lock.Unlock()
+			return nil
+		}
+		switch p.pool.policy {
+		case RejectPolicy:
+			p.lock.Unlock()
+			return fmt.Errorf("pool %s: queue full (%d), reject %s", p.pool.name, cap(p.pool.queue), j.e.Subject)
+		case DropOldestPolicy:
+			p.dropOldest()
+		default:
+			if p.pool.stopped() {
+				p.lock.Unlock()
+				return fmt.Errorf("pool %s: stopped", p.pool.name)
+			}
+			p.cond.Wait()
+		}
+	}
+	p.pending[key] = make([]ordered, 0)
+	p.lock.Unlock()
+
+	err := ctx.pool.dispatch(p.wrap(key, j))
+	if err != nil {
+		// events queued behind the rejected one are already accepted
+		p.resume(key)
+	}
+	return err
+}
+
+// dropOldest drops the oldest queued event of all keys, p.lock is held.
+func (p *order) dropOldest() {
+	key := ""
+	for k, q := range p.pending {
+		if len(q) != 0 && (len(key) == 0 || q[0].seq < p.pending[key][0].seq) {
+			key = k
+		}
+	}
+	if len(key) == 0 {
+		return
+	}
+	old := p.pending[key][0]
+	p.pending[key] = p.pending[key][1:]
+	p.queued--
+	logger.Log.Errorf("pool %s: queue full (%d), drop %s %s", p.pool.name, cap(p.pool.queue), old.j.e.Subject, old.j.e.ID())
+	old.j.drop()
+}
+
+// wrap makes j the head of key: when it is finished or dropped, the
+// next queued event of key is delivered.
+func (p *order) wrap(key string, j job) job {
+	run, drop := j.run, j.drop
+	j.run = func() {
+		run()
+		p.drain(key)
+	}
+	j.drop = func() {
+		drop()
+		p.resume(key)
+	}
+	return j
+}
+
+// drain runs queued events of key in the current goroutine, so the
+// worker is held until the key is idle.
+func (p *order) drain(key string) {
+	for {
+		j, ok := p.next(key)
+		if !ok {
+			return
+		}
+		j.run()
+	}
+}
+
+// resume delivers the next queued event of key by pool after the head
+// of key was dropped or rejected.
+func (p *order) resume(key string) {
+	j, ok := p.next(key)
+	if !ok {
+		return
+	}
+	go func() {
+		if err := p.pool.enqueue(p.wrap(key, j)); err != nil {
+			j.drop()
+			p.resume(key)
+		}
+	}()
+}
+
+// next takes the next queued event of key, false when key is idle.
+// Queued events of stopped pool are dropped.
+func (p *order) next(key string) (job, bool) {
+	p.lock.Lock()
+	defer p.cond.Broadcast()
+	q := p.pending[key]
+	if len(q) == 0 || p.pool.stopped() {
+		delete(p.pending, key)
+		p.queued -= len(q)
+		p.lock.Unlock()
+		for _, o := range q {
+			o.j.drop()
+		}
+		return job{}, false
+	}
+	p.pending[key] = q[1:]
+	p.queued--
+	p.lock.Unlock()
+	return q[0].j, true
+}
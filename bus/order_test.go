@@ -0,0 +1,152 @@
+package bus
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+)
+
+func TestOrder(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("/tmp", "config_")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	cfg := config.New(tmpfile.Name(), config.YAMLAdapter)
+	logger.New(cfg.Get("logger"))
+
+	t.Run("SameKey", func(t *testing.T) {
+		ctx := Context{order: newOrder(ByTrace, nil)}
+		lock := sync.Mutex{}
+		got := make([]int, 0)
+		wg := sync.WaitGroup{}
+		for i := 0; i < 20; i++ {
+			i := i
+			wg.Add(1)
+			dispatch(Event{Trace: "key", Subject: ServeCmdEvent}, ctx, func() {
+				defer wg.Done()
+				time.Sleep(time.Millisecond)
+				lock.Lock()
+				got = append(got, i)
+				lock.Unlock()
+			})
+		}
+		wg.Wait()
+
+		for i, v := range got {
+			if i != v {
+				t.Errorf("order %v", got)
+				break
+			}
+		}
+	})
+
+	t.Run("DifferentKeys", func(t *testing.T) {
+		pool := newPoolWith("test", 2, 10, BlockPolicy)
+		ctx := Context{order: newOrder(ByHeader(OrderKeyHeader), pool), pool: pool}
+		defer ctx.pool.stop()
+
+		release := make(chan struct{})
+		started := make(chan string, 2)
+		for i := 0; i < 2; i++ {
+			e := Event{Subject: ServeCmdEvent}
+			e.SetHeader(OrderKeyHeader, strconv.Itoa(i))
+			dispatch(e, ctx, func() {
+				started <- e.Header(OrderKeyHeader)
+				<-release
+			})
+		}
+		for i := 0; i < 2; i++ {
+			select {
+			case <-started:
+			case <-time.After(time.Second):
+				t.Error("keys are not delivered in parallel")
+			}
+		}
+		close(release)
+	})
+
+	t.Run(DropOldestPolicy, func(t *testing.T) {
+		pool := newPoolWith("test", 1, 1, DropOldestPolicy)
+		b := &EventsBus{}
+		ctx := Context{Bus: b, order: newOrder(ByHeader(OrderKeyHeader), pool), pool: pool}
+
+		release := make(chan struct{})
+		started := make(chan struct{})
+		lock := sync.Mutex{}
+		got := make([]string, 0)
+		for _, name := range []string{"running", "dropped", "queued", "dropped-head", "last"} {
+			name := name
+			e := Event{Subject: ServeCmdEvent}
+			switch name {
+			case "dropped-head":
+				e.SetHeader(OrderKeyHeader, "other")
+			case "last":
+				e.SetHeader(OrderKeyHeader, "last")
+			default:
+				e.SetHeader(OrderKeyHeader, "key")
+			}
+			if err := dispatch(e, ctx, func() {
+				if name == "running" {
+					close(started)
+					<-release
+				}
+				lock.Lock()
+				got = append(got, name)
+				lock.Unlock()
+			}); err != nil {
+				t.Error(err)
+			}
+			if name == "running" {
+				<-started
+			}
+		}
+		close(release)
+
+		deadline := time.Now().Add(time.Second)
+		for b.InFlight() != 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if n := b.InFlight(); n != 0 {
+			t.Errorf("in-flight %d != 0", n)
+		}
+		lock.Lock()
+		if len(got) != 3 || got[0] != "running" {
+			t.Errorf("delivered %v", got)
+		}
+		lock.Unlock()
+
+		// key of dropped head is not stuck
+		done := make(chan struct{})
+		e := Event{Subject: ServeCmdEvent}
+		e.SetHeader(OrderKeyHeader, "other")
+		dispatch(e, ctx, func() { close(done) })
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("key of dropped head is stuck")
+		}
+		pool.stop()
+
+		idle := func() bool {
+			ctx.order.lock.Lock()
+			defer ctx.order.lock.Unlock()
+			return len(ctx.order.pending) == 0 && ctx.order.queued == 0
+		}
+		for !idle() && time.Now().Before(deadline.Add(time.Second)) {
+			time.Sleep(time.Millisecond)
+		}
+		if !idle() {
+			t.Error("keys are not idle")
+		}
+	})
+}
@@ -0,0 +1,204 @@
+package bus
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+)
+
+//config section of task
+//
+//serve:
+//  pool:
+//    workers: 2
+//    queue: 10
+//    policy: block
+//
+
+const (
+	BlockPolicy      = "block"
+	DropOldestPolicy = "drop-oldest"
+	RejectPolicy     = "reject"
+
+	defaultPoolWorkers = 1
+	defaultPoolQueue   = 10
+)
+
+type job struct {
+	e    Event
+	run  func()
+	drop func()
+}
+
+// pool runs deliveries of one subscription by fixed number of workers.
+// When queue is full, policy decides: block publisher, drop the oldest
+// queued event or reject the new one with error.
+type pool struct {
+	name   string
+	policy string
+	queue  chan job
+	quit   chan struct{}
+	once   sync.Once
+}
+
+func newPool(cfg config.ConfigData, name string) *pool {
+	if cfg == nil || !cfg.Exist("pool") {
+		return nil
+	}
+	return newPoolWith(name,
+		cfg.GetIntOr("pool.workers", defaultPoolWorkers),
+		cfg.GetIntOr("pool.queue", defaultPoolQueue),
+		cfg.GetStringOr("pool.policy", BlockPolicy))
+}
+
+func newPoolWith(name string, workers int, queue int, policy string) *pool {
+	switch policy {
+	case BlockPolicy, DropOldestPolicy, RejectPolicy:
+	default:
+		logger.Log.Errorf("pool %s: unknown policy %s, use %s", name, policy, BlockPolicy)
+		policy = BlockPolicy
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	if queue < 1 {
+		queue = 1
+	}
+	p := &pool{
+		name:   name,
+		policy: policy,
+		queue:  make(chan job, queue),
+		quit:   make(chan struct{})}
+	for i := 0; i < workers; i++ {
+		go p.worker()
+	}
+	return p
+}
+
+func (p *pool) worker() {
+	for {
+		select {
+		case j := <-p.queue:
+			j.run()
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+func (p *pool) submit(j job) error {
+	switch p.policy {
+	case RejectPolicy:
+		select {
+		case p.queue <- j:
+			return nil
+		case <-p.quit:
+			return fmt.Errorf("pool %s: stopped", p.name)
+		default:
+			return fmt.Errorf("pool %s: queue full (%d), reject %s", p.name, cap(p.queue), j.e.Subject)
+		}
+	case DropOldestPolicy:
+		for {
+			select {
+			case p.queue <- j:
+				return nil
+			case <-p.quit:
+				return fmt.Errorf("pool %s: stopped", p.name)
+			default:
+				select {
+				case old := <-p.queue:
+					logger.Log.Errorf("pool %s: queue full (%d), drop %s %s", p.name, cap(p.queue), old.e.Subject, old.e.ID())
+					if old.drop != nil {
+						old.drop()
+					}
+				default:
+				}
+			}
+		}
+	default:
+		return p.enqueue(j)
+	}
+}
+
+// enqueue waits for room in queue regardless of policy, it is used for
+// events already accepted by subscription.
+func (p *pool) enqueue(j job) error {
+	if p == nil {
+		go j.run()
+		return nil
+	}
+	select {
+	case p.queue <- j:
+		return nil
+	case <-p.quit:
+		return fmt.Errorf("pool %s: stopped", p.name)
+	}
+}
+
+func (p *pool) stopped() bool {
+	if p == nil {
+		return false
+	}
+	select {
+	case <-p.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *pool) Depth() int {
+	return len(p.queue)
+}
+
+func (p *pool) stop() {
+	p.once.Do(func() {
+		close(p.quit)
+		for {
+			select {
+			case j := <-p.queue:
+				if j.drop != nil {
+					j.drop()
+				}
+			default:
+				return
+			}
+		}
+	})
+}
+
+// dispatch runs job by pool workers, or in a new goroutine when
+// subscription has no pool.
+func (p *pool) dispatch(j job) error {
+	if p == nil {
+		go j.run()
+		return nil
+	}
+	return p.submit(j)
+}
+
+// dispatch counts delivery as in-flight for EventsBus.Shutdown until
+// it is finished or dropped.
+func dispatch(e Event, ctx Context, run func()) error {
+	j := job{e: e, run: run, drop: func() {}}
+	if ctx.Bus != nil {
+		ctx.Bus.begin()
+		j.run = func() {
+			defer ctx.Bus.end()
+			run()
+		}
+		j.drop = ctx.Bus.end
+	}
+	var err error
+	if ctx.order != nil {
+		err = ctx.order.dispatch(j, ctx)
+	} else {
+		err = ctx.pool.dispatch(j)
+	}
+	if err != nil {
+		j.drop()
+	}
+	return err
+}
@@ -0,0 +1,110 @@
+package bus
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+)
+
+func TestPool(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("/tmp", "config_")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	cfg := config.New(tmpfile.Name(), config.YAMLAdapter)
+	logger.New(cfg.Get("logger"))
+
+	t.Run("Workers", func(t *testing.T) {
+		p := newPoolWith("test", 2, 10, BlockPolicy)
+		defer p.stop()
+
+		running, max := int32(0), int32(0)
+		wg := sync.WaitGroup{}
+		for i := 0; i < 6; i++ {
+			wg.Add(1)
+			err := p.submit(job{e: Event{Subject: ServeCmdEvent}, run: func() {
+				defer wg.Done()
+				n := atomic.AddInt32(&running, 1)
+				for {
+					m := atomic.LoadInt32(&max)
+					if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+						break
+					}
+				}
+				time.Sleep(20 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+			}})
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		wg.Wait()
+
+		if max != 2 {
+			t.Errorf("max concurrent %d != 2", max)
+		}
+	})
+
+	t.Run(RejectPolicy, func(t *testing.T) {
+		p := newPoolWith("test", 1, 1, RejectPolicy)
+		defer p.stop()
+
+		release := make(chan struct{})
+		started := make(chan struct{})
+		p.submit(job{e: Event{Subject: ServeCmdEvent}, run: func() {
+			close(started)
+			<-release
+		}})
+		<-started
+		if err := p.submit(job{e: Event{Subject: ServeCmdEvent}, run: func() {}}); err != nil {
+			t.Error(err)
+		}
+		if err := p.submit(job{e: Event{Subject: ServeCmdEvent}, run: func() {}}); err == nil {
+			t.Error("not rejected on full queue")
+		}
+		if p.Depth() != 1 {
+			t.Errorf("depth %d != 1", p.Depth())
+		}
+		close(release)
+	})
+
+	t.Run(DropOldestPolicy, func(t *testing.T) {
+		p := newPoolWith("test", 1, 1, DropOldestPolicy)
+		defer p.stop()
+
+		release := make(chan struct{})
+		started := make(chan struct{})
+		done := make(chan string, 2)
+		p.submit(job{e: Event{Subject: ServeCmdEvent}, run: func() {
+			close(started)
+			<-release
+		}})
+		<-started
+		for _, name := range []string{"oldest", "newest"} {
+			name := name
+			if err := p.submit(job{e: Event{Subject: ServeCmdEvent}, run: func() { done <- name }}); err != nil {
+				t.Error(err)
+			}
+		}
+		close(release)
+
+		select {
+		case name := <-done:
+			if name != "newest" {
+				t.Errorf("%s != newest", name)
+			}
+		case <-time.After(time.Second):
+			t.Error("newest not delivered")
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package bus
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mhanygin/broforce/logger"
+)
+
+var ErrTimeout = errors.New("request timeout")
+
+func isInbox(subject string) bool {
+	return strings.HasPrefix(subject, InboxEvent+subjectSep)
+}
+
+// Request publishes event with unique ReplyTo subject and waits for
+// the first reply. Replies do not pass through adapters, they are
+// delivered to the waiting request directly.
+func (p *EventsBus) Request(e Event, timeout time.Duration) (Event, error) {
+	e.ReplyTo = fmt.Sprintf("%s%s%s", InboxEvent, subjectSep, NewUUID())
+	ch := make(chan Event, 1)
+
+	p.lock.Lock()
+	if p.inbox == nil {
+		p.inbox = make(map[string]chan Event)
+	}
+	p.inbox[e.ReplyTo] = ch
+	p.lock.Unlock()
+
+	defer func() {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+
+		delete(p.inbox, e.ReplyTo)
+	}()
+
+	if err := p.Publish(e); err != nil {
+		return Event{}, err
+	}
+	select {
+	case reply := <-ch:
+		return reply, nil
+	case <-time.After(timeout):
+		return Event{}, ErrTimeout
+	}
+}
+
+func (p *EventsBus) reply(e Event) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	ch, ok := p.inbox[e.Subject]
+	if !ok {
+		logger.Log.Debugf("reply %s: request not waiting", e.Subject)
+		return nil
+	}
+	select {
+	case ch <- e:
+	default:
+		logger.Log.Debugf("reply %s: request already replied", e.Subject)
+	}
+	return nil
+}
+
+// Reply publishes data as reply to request, with request coding.
+func Reply(ctx Context, request Event, data interface{}) error {
+	if len(request.ReplyTo) == 0 {
+		return fmt.Errorf("event %s without reply-to", request.Subject)
+	}
+	reply, err := request.DeriveWithData(request.ReplyTo, request.Coding, data)
+	if err != nil {
+		return err
+	}
+	return ctx.Publish(*reply)
+}
@@ -0,0 +1,88 @@
+package bus
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+)
+
+func TestRequest(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("/tmp", "config_")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	cfg := config.New(tmpfile.Name(), config.YAMLAdapter)
+	logger.New(cfg.Get("logger"))
+
+	saved := busAdapters
+	defer func() { busAdapters = saved }()
+
+	a := &simpleAdapter{}
+	a.Run(cfg.Get("simple"))
+	busAdapters = []*adapterConfig{
+		&adapterConfig{
+			Name:       "simple",
+			EventTypes: []*regexp.Regexp{regexp.MustCompile(".*")},
+			Adapter:    a}}
+
+	b := &EventsBus{}
+	b.Subscribe("gocd.status", Context{
+		Name: "StatusHandler",
+		Bus:  b,
+		Func: func(e Event, ctx Context) error {
+			pipeline := ""
+			if err := e.Unmarshal(&pipeline); err != nil {
+				return err
+			}
+			return Reply(ctx, e, pipeline+": passed")
+		}})
+
+	t.Run("Reply", func(t *testing.T) {
+		e, _ := NewEventWithData("trace", "gocd.status", JsonCoding, "deploy")
+		reply, err := b.Request(*e, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status := ""
+		if err := reply.Unmarshal(&status); err != nil {
+			t.Fatal(err)
+		}
+		if status != "deploy: passed" {
+			t.Errorf("%s != deploy: passed", status)
+		}
+		if reply.Trace != "trace" {
+			t.Errorf("%s != trace", reply.Trace)
+		}
+		if len(b.inbox) != 0 {
+			t.Errorf("inbox not empty: %v", b.inbox)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		b.Subscribe("gocd.silent", Context{
+			Name: "SilentHandler",
+			Bus:  b,
+			Func: func(e Event, ctx Context) error {
+				return nil
+			}})
+		e := NewEvent("trace", "gocd.silent", JsonCoding)
+		if _, err := b.Request(*e, 100*time.Millisecond); err != ErrTimeout {
+			t.Errorf("%v != %v", err, ErrTimeout)
+		}
+	})
+
+	t.Run("WithoutReplyTo", func(t *testing.T) {
+		if err := Reply(Context{Bus: b}, Event{Subject: "gocd.status"}, "status"); err == nil {
+			t.Error("reply without reply-to")
+		}
+	})
+}
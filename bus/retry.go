@@ -0,0 +1,144 @@
+package bus
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+)
+
+//config section of task
+//
+//serve:
+//  retry:
+//    retry: 5
+//    delay: 500ms
+//    max-delay: 1m
+//    multiplier: 2
+//    jitter: 0.2
+//
+
+type permanentError struct {
+	err error
+}
+
+func (p permanentError) Error() string {
+	return p.err.Error()
+}
+
+// Permanent marks err as not retryable, SafeRun and SafeHandler stop
+// retrying on it.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+func IsPermanent(err error) bool {
+	_, ok := err.(permanentError)
+	return ok
+}
+
+// NewSafeParams reads retry policy from cfg, missing values are taken
+// from def. Delays are durations (`500ms`, `1m`) or number of seconds.
+func NewSafeParams(cfg config.ConfigData, def SafeParams) SafeParams {
+	if cfg == nil {
+		return def
+	}
+	sp := def
+	sp.Retry = cfg.GetIntOr("retry", def.Retry)
+	sp.Delay = getDuration(cfg, "delay", def.Delay)
+	sp.MaxDelay = getDuration(cfg, "max-delay", def.MaxDelay)
+	if cfg.Exist("multiplier") {
+		sp.Multiplier = cfg.GetFloat("multiplier")
+	}
+	if cfg.Exist("jitter") {
+		sp.Jitter = cfg.GetFloat("jitter")
+	}
+	return sp
+}
+
+func getDuration(cfg config.ConfigData, path string, def time.Duration) time.Duration {
+	if !cfg.Exist(path) {
+		return def
+	}
+	d, err := cfg.GetDuration(path)
+	if err != nil {
+		logger.Log.Errorf("Error: %v", err)
+		return def
+	}
+	return d
+}
+
+// maxBackoff caps delay when MaxDelay is not set, so large attempts
+// do not overflow time.Duration.
+const maxBackoff = time.Duration(math.MaxInt64)
+
+// Backoff returns delay before retry after attempt (counted from 1).
+func (p SafeParams) Backoff(attempt int) time.Duration {
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 1
+	}
+	max := float64(maxBackoff)
+	if p.MaxDelay > 0 {
+		max = float64(p.MaxDelay)
+	}
+	d := float64(p.Delay) * math.Pow(mult, float64(attempt-1))
+	if d > max {
+		d = max
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	switch {
+	case d < 0 || math.IsNaN(d):
+		return 0
+	case d >= float64(maxBackoff):
+		// float64(MaxInt64) rounds up to 2^63, which does not fit
+		return maxBackoff
+	}
+	return time.Duration(d)
+}
+
+func (p SafeParams) retryable(err error) bool {
+	if IsPermanent(err) {
+		return false
+	}
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return true
+}
+
+// Do calls f until it succeeds, the error is not retryable or Retry
+// retries are spent; negative Retry retries forever. The last error
+// is returned.
+func (p SafeParams) Do(f func(attempt int) error) error {
+	return p.DoContext(context.Background(), f)
+}
+
+// DoContext is Do stopping retries when ctx is done.
+func (p SafeParams) DoContext(ctx context.Context, f func(attempt int) error) error {
+	for attempt := 1; ; attempt++ {
+		err := f(attempt)
+		if err == nil {
+			return nil
+		}
+		if !p.retryable(err) || (p.Retry >= 0 && attempt > p.Retry) {
+			if pe, ok := err.(permanentError); ok {
+				return pe.err
+			}
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(p.Backoff(attempt)):
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package bus
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mhanygin/broforce/config"
+)
+
+func TestRetry(t *testing.T) {
+	t.Run("Backoff", func(t *testing.T) {
+		sp := SafeParams{Delay: time.Second, Multiplier: 2, MaxDelay: 5 * time.Second}
+		for attempt, d := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second} {
+			if b := sp.Backoff(attempt); b != d {
+				t.Errorf("attempt %d: %v != %v", attempt, b, d)
+			}
+		}
+		sp.Jitter = 0.5
+		for i := 0; i < 100; i++ {
+			if b := sp.Backoff(1); b < 500*time.Millisecond || b > 1500*time.Millisecond {
+				t.Errorf("jitter %v out of range", b)
+			}
+		}
+
+		sp = SafeParams{Delay: time.Second, Multiplier: 2}
+		for _, attempt := range []int{64, 100, 10000} {
+			if b := sp.Backoff(attempt); b != maxBackoff {
+				t.Errorf("attempt %d without max-delay: %v != %v", attempt, b, maxBackoff)
+			}
+		}
+		sp.Jitter = 0.5
+		if b := sp.Backoff(10000); b <= 0 {
+			t.Errorf("attempt 10000 with jitter: %v", b)
+		}
+	})
+
+	t.Run("Do", func(t *testing.T) {
+		attempts := 0
+		err := SafeParams{Retry: 3, Delay: 1}.Do(func(attempt int) error {
+			attempts = attempt
+			return fmt.Errorf("attempt %d", attempt)
+		})
+		if attempts != 4 || err == nil || err.Error() != "attempt 4" {
+			t.Errorf("attempts %d, err %v", attempts, err)
+		}
+	})
+
+	t.Run("Permanent", func(t *testing.T) {
+		attempts := 0
+		err := SafeParams{Retry: 3, Delay: 1}.Do(func(attempt int) error {
+			attempts = attempt
+			return Permanent(fmt.Errorf("bad request"))
+		})
+		if attempts != 1 || err == nil || IsPermanent(err) {
+			t.Errorf("attempts %d, err %v", attempts, err)
+		}
+	})
+
+	t.Run("Retryable", func(t *testing.T) {
+		attempts := 0
+		sp := SafeParams{Retry: 3, Delay: 1, Retryable: func(err error) bool { return err.Error() != "fatal" }}
+		sp.Do(func(attempt int) error {
+			attempts = attempt
+			if attempt == 2 {
+				return fmt.Errorf("fatal")
+			}
+			return fmt.Errorf("temporary")
+		})
+		if attempts != 2 {
+			t.Errorf("attempts %d != 2", attempts)
+		}
+	})
+
+	t.Run("NewSafeParams", func(t *testing.T) {
+		tmpfile, err := ioutil.TempFile("/tmp", "config_")
+		if err != nil {
+			t.Error(err)
+			t.Fail()
+		}
+		tmpfile.Close()
+		defer os.Remove(tmpfile.Name())
+
+		cfg := config.New(tmpfile.Name(), config.YAMLAdapter)
+		def := SafeParams{Retry: 2, Delay: time.Second, Jitter: 0.1}
+		if sp := NewSafeParams(cfg.Get("retry"), def); sp.Retry != def.Retry || sp.Delay != def.Delay || sp.Jitter != def.Jitter {
+			t.Errorf("%+v", sp)
+		}
+	})
+}
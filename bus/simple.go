@@ -1,7 +1,9 @@
 package bus
 
 import (
+	"context"
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
@@ -14,52 +16,90 @@ func init() {
 
 func SafeHandler(h Handler, sp SafeParams) Handler {
 	return func(e Event, ctx Context) error {
-		for {
-			if err := h(e, ctx); err != nil {
+		attempts, first, last := 0, time.Now(), time.Now()
+		if ctx.Ctx == nil {
+			ctx.Ctx = context.Background()
+		}
+		err := sp.DoContext(ctx.Ctx, func(attempt int) error {
+			attempts, last = attempt, time.Now()
+			if attempt > 1 {
+				ctx.Log.Infof("Retry %d", attempt-1)
+			}
+			e.SetHeader(AttemptHeader, strconv.Itoa(attempt))
+			err := h(e, ctx)
+			if err != nil {
 				ctx.Log.Error(err)
-				if sp.Retry <= 0 {
-					return err
-				} else {
-					sp.Retry--
-					time.Sleep(sp.Delay)
-					ctx.Log.Infof("Retry %d", sp.Retry)
-				}
-			} else {
-				break
 			}
+			return err
+		})
+		if err != nil {
+			return deadLetter(e, ctx, err, attempts, first, last)
 		}
 		return nil
 	}
 }
 
 type simpleAdapter struct {
-	subs map[string][]Context
+	subs map[string]map[uint64]Context
+	seq  uint64
 	lock sync.Mutex
 }
 
 func (p *simpleAdapter) Run(cfg config.ConfigData) error {
 	p.lock = sync.Mutex{}
-	p.subs = make(map[string][]Context)
+	p.subs = make(map[string]map[uint64]Context)
 	return nil
 }
 
 func (p *simpleAdapter) Publish(e Event) error {
-	if _, ok := p.subs[e.Subject]; !ok {
+	p.lock.Lock()
+	subs := make([]Context, 0)
+	for subject, s := range p.subs {
+		if !MatchSubject(subject, e.Subject) {
+			continue
+		}
+		for _, ctx := range s {
+			subs = append(subs, ctx)
+		}
+	}
+	p.lock.Unlock()
+
+	if len(subs) == 0 {
 		return fmt.Errorf("subs for %s empty", e.Subject)
 	}
-	for _, ctx := range p.subs[e.Subject] {
-		go ctx.Func(e, ctx)
+	errs := make(Errors, 0)
+	for _, ctx := range subs {
+		ctx := ctx
+		if err := dispatch(e, ctx, func() { ctx.Func(e, ctx) }); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) != 0 {
+		return errs
 	}
 	return nil
 }
 
-func (p *simpleAdapter) Subscribe(subject string, ctx Context) {
+func (p *simpleAdapter) Subscribe(subject string, ctx Context) Subscription {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
+	subject = Canonical(subject)
 	if _, ok := p.subs[subject]; !ok {
-		p.subs[subject] = make([]Context, 0)
+		p.subs[subject] = make(map[uint64]Context)
 	}
-	ctx.Func = SafeHandler(ctx.Func, SafeParams{Retry: 0, Delay: time.Duration(1)})
-	p.subs[subject] = append(p.subs[subject], ctx)
+	ctx.Func = SafeHandler(ctx.Func, ctx.Retry)
+	p.seq++
+	id := p.seq
+	p.subs[subject][id] = ctx
+
+	return newSubscription(func() {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+
+		delete(p.subs[subject], id)
+		if len(p.subs[subject]) == 0 {
+			delete(p.subs, subject)
+		}
+	})
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -60,4 +61,29 @@ func TestSimple(t *testing.T) {
 			t.Fail()
 		}
 	})
+	t.Run("Unsubscribe", func(t *testing.T) {
+		got := int32(0)
+		a := &simpleAdapter{}
+		a.Run(cfg.Get("simple"))
+
+		handler := func(e Event, ctx Context) error {
+			atomic.AddInt32(&got, 1)
+			return nil
+		}
+
+		sub := a.Subscribe(UnknownEvent, Context{Func: handler, Name: "TestHandler", Log: ctx.Log})
+		a.Subscribe(UnknownEvent, Context{Func: handler, Name: "OtherHandler", Log: ctx.Log})
+		sub.Unsubscribe()
+		sub.Unsubscribe()
+		a.Publish(Event{Subject: UnknownEvent, Data: []byte(""), Coding: JsonCoding})
+
+		time.Sleep(100 * time.Millisecond)
+
+		if atomic.LoadInt32(&got) != 1 {
+			t.Errorf("got %d != 1", got)
+		}
+		if _, ok := a.subs[Canonical(UnknownEvent)]; !ok {
+			t.Error("other subscription removed")
+		}
+	})
 }
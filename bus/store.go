@@ -0,0 +1,299 @@
+package bus
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+)
+
+//config section
+//
+//bus:
+//  event-store:
+//    path: /var/lib/broforce/events
+//    retention: 7
+//
+
+const (
+	defaultEventStorePath = "events"
+	defaultRetention      = 7
+	eventStorePrefix      = "events-"
+	eventStoreExt         = ".jsonl"
+	eventStoreDay         = "2006-01-02"
+	maxStoredEvent        = 64 * 1024 * 1024
+)
+
+var events *EventStore
+
+type StoredEvent struct {
+	Stored time.Time `json:"stored"`
+	Event  Event     `json:"event"`
+}
+
+// EventQuery selects stored events, empty fields match any event.
+// Subject may contain wildcards.
+type EventQuery struct {
+	ID      string
+	Subject string
+	Trace   string
+	From    time.Time
+	To      time.Time
+	Limit   int
+}
+
+// EventStore records every published event, one json line per event
+// in a file per day. Files older than retention days are removed.
+type EventStore struct {
+	path      string
+	retention int
+	lock      sync.Mutex
+	day       string
+	file      *os.File
+}
+
+func NewEventStore(cfg config.ConfigData) (*EventStore, error) {
+	return newEventStore(cfg.GetStringOr("path", defaultEventStorePath), cfg.GetIntOr("retention", defaultRetention))
+}
+
+func newEventStore(path string, retention int) (*EventStore, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	return &EventStore{path: path, retention: retention}, nil
+}
+
+// GetEventStore returns event store of the bus, nil when not configured.
+func GetEventStore() *EventStore {
+	return events
+}
+
+func (p *EventStore) Append(e Event) error {
+	data, err := json.Marshal(StoredEvent{Stored: time.Now(), Event: e})
+	if err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if err := p.rotate(time.Now().Format(eventStoreDay)); err != nil {
+		return err
+	}
+	_, err = p.file.Write(append(data, '\n'))
+	return err
+}
+
+func (p *EventStore) rotate(day string) error {
+	if p.file != nil && p.day == day {
+		return nil
+	}
+	if p.file != nil {
+		p.file.Close()
+		p.file = nil
+	}
+	f, err := os.OpenFile(filepath.Join(p.path, eventStorePrefix+day+eventStoreExt), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	p.file, p.day = f, day
+	p.cleanup(time.Now().AddDate(0, 0, -p.retention))
+	return nil
+}
+
+func (p *EventStore) cleanup(before time.Time) {
+	if p.retention <= 0 {
+		return
+	}
+	days, err := p.days()
+	if err != nil {
+		logger.Log.Errorf("event store: %v", err)
+		return
+	}
+	for _, day := range days {
+		if t, _ := time.Parse(eventStoreDay, day); t.Before(before) {
+			if err := os.Remove(filepath.Join(p.path, eventStorePrefix+day+eventStoreExt)); err != nil {
+				logger.Log.Errorf("event store: %v", err)
+			}
+		}
+	}
+}
+
+func (p *EventStore) days() ([]string, error) {
+	out := make([]string, 0)
+	files, err := ioutil.ReadDir(p.path)
+	if err != nil {
+		return out, err
+	}
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, eventStorePrefix) || !strings.HasSuffix(name, eventStoreExt) {
+			continue
+		}
+		out = append(out, strings.TrimSuffix(strings.TrimPrefix(name, eventStorePrefix), eventStoreExt))
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (p *EventStore) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.file == nil {
+		return nil
+	}
+	err := p.file.Close()
+	p.file = nil
+	return err
+}
+
+func (p EventQuery) match(s StoredEvent) bool {
+	switch {
+	case len(p.ID) != 0 && p.ID != s.Event.ID():
+		return false
+	case len(p.Subject) != 0 && !MatchSubject(p.Subject, s.Event.Subject):
+		return false
+	case len(p.Trace) != 0 && p.Trace != s.Event.Trace:
+		return false
+	case !p.From.IsZero() && s.Stored.Before(p.From):
+		return false
+	case !p.To.IsZero() && s.Stored.After(p.To):
+		return false
+	}
+	return true
+}
+
+// Query returns stored events matching q in order of publishing.
+func (p *EventStore) Query(q EventQuery) ([]StoredEvent, error) {
+	out := make([]StoredEvent, 0)
+	days, err := p.days()
+	if err != nil {
+		return out, err
+	}
+	for _, day := range days {
+		t, err := time.ParseInLocation(eventStoreDay, day, time.Local)
+		if err != nil {
+			continue
+		}
+		if (!q.From.IsZero() && t.AddDate(0, 0, 1).Before(q.From)) || (!q.To.IsZero() && t.After(q.To)) {
+			continue
+		}
+		if out, err = p.scan(day, q, out); err != nil {
+			return out, err
+		}
+		if q.Limit > 0 && len(out) >= q.Limit {
+			return out[:q.Limit], nil
+		}
+	}
+	return out, nil
+}
+
+func (p *EventStore) scan(day string, q EventQuery, out []StoredEvent) ([]StoredEvent, error) {
+	f, err := os.Open(filepath.Join(p.path, eventStorePrefix+day+eventStoreExt))
+	if err != nil {
+		return out, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), maxStoredEvent)
+	for s.Scan() {
+		se := StoredEvent{}
+		if err := json.Unmarshal(s.Bytes(), &se); err != nil {
+			logger.Log.Errorf("event store: %s: %v", day, err)
+			continue
+		}
+		if q.match(se) {
+			out = append(out, se)
+		}
+	}
+	return out, s.Err()
+}
+
+// Replay publishes events matching q again. Replayed event gets new
+// id, replay-of header refers to the original one. Events derived from
+// other events (with causation-id) are published again by handlers of
+// the replayed ones, so they are skipped unless all is set.
+func (p *EventStore) Replay(b *EventsBus, q EventQuery, all bool) ([]string, error) {
+	list, err := p.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(list))
+	errs := make(Errors, 0)
+	for _, se := range list {
+		e := se.Event
+		if !all && len(e.CausationID()) != 0 {
+			continue
+		}
+		e.SetHeader(ReplayHeader, e.ID())
+		e.SetHeader(IDHeader, NewUUID())
+		e.SetHeader(TimestampHeader, time.Now().Format(time.RFC3339Nano))
+		if err := b.Publish(e); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", se.Event.ID(), err))
+			continue
+		}
+		logger.Log.Infof("event store: replay %s %s as %s", se.Event.ID(), e.Subject, e.ID())
+		ids = append(ids, e.ID())
+	}
+	if len(errs) != 0 {
+		return ids, errs
+	}
+	return ids, nil
+}
+
+// Values encodes query as url parameters, NewEventQuery decodes them.
+func (p EventQuery) Values() url.Values {
+	v := url.Values{}
+	set := func(key, value string) {
+		if len(value) != 0 {
+			v.Set(key, value)
+		}
+	}
+	set("id", p.ID)
+	set("subject", p.Subject)
+	set("trace", p.Trace)
+	if !p.From.IsZero() {
+		v.Set("from", p.From.Format(time.RFC3339))
+	}
+	if !p.To.IsZero() {
+		v.Set("to", p.To.Format(time.RFC3339))
+	}
+	if p.Limit > 0 {
+		v.Set("limit", strconv.Itoa(p.Limit))
+	}
+	return v
+}
+
+func NewEventQuery(v url.Values) (EventQuery, error) {
+	var err error
+	q := EventQuery{ID: v.Get("id"), Subject: v.Get("subject"), Trace: v.Get("trace")}
+	if s := v.Get("from"); len(s) != 0 {
+		if q.From, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, fmt.Errorf("from: %v", err)
+		}
+	}
+	if s := v.Get("to"); len(s) != 0 {
+		if q.To, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, fmt.Errorf("to: %v", err)
+		}
+	}
+	if s := v.Get("limit"); len(s) != 0 {
+		if q.Limit, err = strconv.Atoi(s); err != nil {
+			return q, fmt.Errorf("limit: %v", err)
+		}
+	}
+	return q, nil
+}
@@ -0,0 +1,130 @@
+package bus
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+)
+
+func TestEventStore(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("/tmp", "config_")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	cfg := config.New(tmpfile.Name(), config.YAMLAdapter)
+	logger.New(cfg.Get("logger"))
+
+	dir, err := ioutil.TempDir("/tmp", "events_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := filepath.Join(dir, eventStorePrefix+time.Now().AddDate(0, 0, -10).Format(eventStoreDay)+eventStoreExt)
+	if err := ioutil.WriteFile(old, []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := newEventStore(dir, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events = store
+	defer func() { events = nil }()
+
+	saved := busAdapters
+	defer func() { busAdapters = saved }()
+	lock := sync.Mutex{}
+	order := make([]string, 0)
+	adapter := &testAdapter{name: "test", order: &order, lock: &lock}
+	busAdapters = []*adapterConfig{
+		&adapterConfig{
+			Name:       "test",
+			EventTypes: []*regexp.Regexp{regexp.MustCompile(".*")},
+			Adapter:    adapter}}
+
+	b := &EventsBus{}
+	serve := NewEvent("trace-1", ServeCmdEvent, JsonCoding)
+	if err := b.Publish(*serve); err != nil {
+		t.Error(err)
+	}
+	if err := b.Publish(*NewEvent("trace-2", TimerEvent, JsonCoding)); err != nil {
+		t.Error(err)
+	}
+
+	t.Run("Retention", func(t *testing.T) {
+		if _, err := os.Stat(old); !os.IsNotExist(err) {
+			t.Errorf("outdated file not removed: %v", err)
+		}
+	})
+	t.Run("Query", func(t *testing.T) {
+		all, err := store.Query(EventQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != 2 || all[0].Event.ID() != serve.ID() {
+			t.Fatalf("events %v", all)
+		}
+		if list, _ := store.Query(EventQuery{Subject: "serve.*"}); len(list) != 1 || list[0].Event.Trace != "trace-1" {
+			t.Errorf("by subject %v", list)
+		}
+		if list, _ := store.Query(EventQuery{Trace: "trace-2"}); len(list) != 1 || list[0].Event.Subject != TimerEvent {
+			t.Errorf("by trace %v", list)
+		}
+		if list, _ := store.Query(EventQuery{From: time.Now().Add(time.Minute)}); len(list) != 0 {
+			t.Errorf("by time %v", list)
+		}
+		if list, _ := store.Query(EventQuery{Limit: 1}); len(list) != 1 {
+			t.Errorf("limit %v", list)
+		}
+	})
+	t.Run("Values", func(t *testing.T) {
+		q := EventQuery{Subject: "git.>", Trace: "trace", From: time.Now().Add(-time.Hour).Truncate(time.Second), Limit: 5}
+		got, err := NewEventQuery(q.Values())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Subject != q.Subject || got.Trace != q.Trace || !got.From.Equal(q.From) || got.Limit != q.Limit || !got.To.IsZero() {
+			t.Errorf("%v != %v", got, q)
+		}
+	})
+	t.Run("Replay", func(t *testing.T) {
+		derived := serve.Derive(OutdatedEvent, JsonCoding)
+		if err := b.Publish(*derived); err != nil {
+			t.Fatal(err)
+		}
+		ids, err := store.Replay(b, EventQuery{Trace: "trace-1"}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != 1 || ids[0] == serve.ID() {
+			t.Fatalf("replayed %v", ids)
+		}
+		e := adapter.events[len(adapter.events)-1]
+		if e.ID() != ids[0] || e.Header(ReplayHeader) != serve.ID() || e.Trace != "trace-1" {
+			t.Errorf("replayed event %v", e)
+		}
+		if list, _ := store.Query(EventQuery{Trace: "trace-1"}); len(list) != 3 {
+			t.Errorf("replayed event not recorded: %v", list)
+		}
+
+		ids, err = store.Replay(b, EventQuery{ID: derived.ID()}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != 1 || adapter.events[len(adapter.events)-1].Header(ReplayHeader) != derived.ID() {
+			t.Errorf("derived event not replayed with all: %v", ids)
+		}
+	})
+}
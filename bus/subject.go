@@ -0,0 +1,80 @@
+package bus
+
+import (
+	"strings"
+)
+
+const (
+	subjectSep       = "."
+	subjectWildcard  = "*"
+	subjectRemainder = ">"
+)
+
+var subjectAliases = map[string]string{
+	TimerEvent:            "timer.tick",
+	GithubHookEvent:       "git.github.push",
+	GitlabHookEvent:       "git.gitlab.push",
+	ServeCmdEvent:         "serve.manifest",
+	ServeCmdWithDataEvent: "serve.data",
+	OutdatedEvent:         "consul.outdated",
+	SlackMsgEvent:         "slack.message",
+	SlackPostEvent:        "slack.post",
+	TelegramMsgEvent:      "telegram.message",
+	JiraHookEvent:         "jira.hook",
+	UnknownEvent:          "unknown",
+	CircuitEvent:          "circuit.state",
+}
+
+// Canonical returns hierarchical form of subject, flat constants
+// from const.go are aliases of hierarchical subjects.
+func Canonical(subject string) string {
+	if s, ok := subjectAliases[subject]; ok {
+		return s
+	}
+	return subject
+}
+
+var flatAliases = func() map[string]string {
+	out := make(map[string]string, len(subjectAliases))
+	for flat, s := range subjectAliases {
+		out[s] = flat
+	}
+	return out
+}()
+
+// Flat returns flat constant aliasing hierarchical subject, subject
+// itself when it has no alias.
+func Flat(subject string) string {
+	if s, ok := flatAliases[subject]; ok {
+		return s
+	}
+	return subject
+}
+
+func isWildcard(pattern string) bool {
+	for _, t := range strings.Split(Canonical(pattern), subjectSep) {
+		if t == subjectWildcard || t == subjectRemainder {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchSubject reports whether subject matches pattern: `*` matches
+// exactly one token, `>` matches one or more tokens at the end.
+func MatchSubject(pattern string, subject string) bool {
+	pt := strings.Split(Canonical(pattern), subjectSep)
+	st := strings.Split(Canonical(subject), subjectSep)
+	for i, t := range pt {
+		if t == subjectRemainder {
+			return i == len(pt)-1 && len(st) > i
+		}
+		if i >= len(st) {
+			return false
+		}
+		if t != subjectWildcard && t != st[i] {
+			return false
+		}
+	}
+	return len(pt) == len(st)
+}
@@ -0,0 +1,109 @@
+package bus
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+)
+
+func TestSubject(t *testing.T) {
+	t.Run("MatchSubject", func(t *testing.T) {
+		tests := []struct {
+			pattern string
+			subject string
+			match   bool
+		}{
+			{"git.github.push", "git.github.push", true},
+			{"git.>", "git.github.push", true},
+			{"git.>", "git", false},
+			{"git.*", "git.github.push", false},
+			{"git.*.push", "git.gitlab.push", true},
+			{"slack.*", SlackMsgEvent, true},
+			{"slack.*", SlackPostEvent, true},
+			{"slack.*", TelegramMsgEvent, false},
+			{GitlabHookEvent, "git.gitlab.push", true},
+			{"git.>", GithubHookEvent, true},
+			{ServeCmdEvent, ServeCmdEvent, true},
+			{ServeCmdEvent, ServeCmdWithDataEvent, false},
+			{">", OutdatedEvent, true},
+			{"git.>.push", "git.github.push", false},
+		}
+		for _, tt := range tests {
+			if MatchSubject(tt.pattern, tt.subject) != tt.match {
+				t.Errorf("MatchSubject(%s, %s) != %v", tt.pattern, tt.subject, tt.match)
+			}
+		}
+	})
+
+	t.Run("Aliases", func(t *testing.T) {
+		if Flat("git.github.push") != GithubHookEvent || Flat("git.>") != "git.>" {
+			t.Errorf("Flat(git.github.push) = %s", Flat("git.github.push"))
+		}
+		flat := &adapterConfig{EventTypes: []*regexp.Regexp{regexp.MustCompile("^(GITHUB|GITLAB)$")}}
+		hierarchical := &adapterConfig{EventTypes: []*regexp.Regexp{regexp.MustCompile(`^git\.`)}}
+		for _, subject := range []string{GithubHookEvent, "git.github.push", "git.gitlab.push"} {
+			if !flat.match(subject) || !hierarchical.match(subject) {
+				t.Errorf("%s not matched", subject)
+			}
+		}
+		if flat.match("git.bitbucket.push") {
+			t.Error("git.bitbucket.push matched")
+		}
+	})
+
+	t.Run("SubjectIs", func(t *testing.T) {
+		e := Event{Subject: "serve.manifest"}
+		if !e.SubjectIs(ServeCmdEvent) {
+			t.Errorf("%s is not %s", e.Subject, ServeCmdEvent)
+		}
+	})
+
+	t.Run("Wildcard", func(t *testing.T) {
+		tmpfile, err := ioutil.TempFile("/tmp", "config_")
+		if err != nil {
+			t.Error(err)
+			t.Fail()
+		}
+		tmpfile.Close()
+		defer os.Remove(tmpfile.Name())
+
+		cfg := config.New(tmpfile.Name(), config.YAMLAdapter)
+		logger.New(cfg.Get("logger"))
+
+		lock := sync.Mutex{}
+		got := make([]string, 0)
+		a := &simpleAdapter{}
+		a.Run(cfg.Get("simple"))
+		a.Subscribe("slack.*", Context{
+			Name: "TestHandler",
+			Log:  logger.Logger4Handler("TestHandler", ""),
+			Func: func(e Event, ctx Context) error {
+				lock.Lock()
+				defer lock.Unlock()
+				got = append(got, e.Subject)
+				return nil
+			}})
+
+		for _, s := range []string{SlackMsgEvent, SlackPostEvent, "slack.reaction"} {
+			if err := a.Publish(Event{Subject: s, Coding: JsonCoding}); err != nil {
+				t.Error(err)
+			}
+		}
+		if err := a.Publish(Event{Subject: GitlabHookEvent, Coding: JsonCoding}); err == nil {
+			t.Errorf("%s delivered to slack.*", GitlabHookEvent)
+		}
+		time.Sleep(100 * time.Millisecond)
+
+		lock.Lock()
+		defer lock.Unlock()
+		if len(got) != 3 {
+			t.Errorf("got %v", got)
+		}
+	})
+}
@@ -0,0 +1,30 @@
+package bus
+
+import (
+	"sync"
+)
+
+type Subscription interface {
+	Unsubscribe()
+}
+
+type subscriptions []Subscription
+
+func (p subscriptions) Unsubscribe() {
+	for _, s := range p {
+		s.Unsubscribe()
+	}
+}
+
+type subscription struct {
+	once        sync.Once
+	unsubscribe func()
+}
+
+func newSubscription(unsubscribe func()) Subscription {
+	return &subscription{unsubscribe: unsubscribe}
+}
+
+func (p *subscription) Unsubscribe() {
+	p.once.Do(p.unsubscribe)
+}
@@ -0,0 +1,307 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+)
+
+//config section of task
+//
+//slackSensor:
+//  restart:
+//    policy: on-failure
+//    retry: 5
+//    delay: 10s
+//    multiplier: 2
+//    max-delay: 5m
+//
+
+const (
+	StartingState = "starting"
+	RunningState  = "running"
+	FailedState   = "failed"
+	StoppedState  = "stopped"
+
+	NeverRestart     = "never"
+	OnFailureRestart = "on-failure"
+	AlwaysRestart    = "always"
+)
+
+// startSections of task config are read by the bus when the task and
+// its subscriptions start, Reload does not apply them.
+var startSections = []string{"retry", "pool", "restart", "breaker"}
+
+var defaultRestart = SafeParams{Retry: 3, Delay: 10 * time.Second, Multiplier: 2, MaxDelay: 5 * time.Minute}
+
+// Lifecycle is optional extension of Task. Init is called until it
+// succeeds, retried by restart policy. Run is the start of task and may
+// be called again on restart, so subscriptions belong to Init. Policy
+// `always` is applied only to Lifecycle tasks, other tasks are not
+// restarted by default. Stop is called on shutdown after
+// ctx.Ctx of Run is cancelled, Health reports current problem of the
+// running task.
+type Lifecycle interface {
+	Task
+	Init(ctx Context) error
+	Stop(ctx Context) error
+	Health() error
+}
+
+// Reloader is optional extension of Task applying changed config
+// section without restart, ctx.Config is the new section.
+type Reloader interface {
+	Reload(ctx Context) error
+}
+
+type TaskState struct {
+	Name     string    `json:"name"`
+	State    string    `json:"state"`
+	Since    time.Time `json:"since"`
+	Restarts int       `json:"restarts"`
+	Error    string    `json:"error,omitempty"`
+	Health   string    `json:"health,omitempty"`
+}
+
+type supervised struct {
+	task    Task
+	ctx     Context
+	policy  string
+	restart SafeParams
+	inited  bool
+	state   TaskState
+}
+
+// Supervisor runs tasks, tracks their states and restarts failed ones
+// by `restart` policy of task config.
+type Supervisor struct {
+	lock  sync.Mutex
+	tasks map[string]*supervised
+	wg    sync.WaitGroup
+}
+
+func NewSupervisor() *Supervisor {
+	return &Supervisor{tasks: make(map[string]*supervised)}
+}
+
+func (p *Supervisor) Start(t Task, ctx Context) {
+	_, lifecycle := t.(Lifecycle)
+	// Run of task without Lifecycle subscribes, running it again would
+	// add handlers, so it is restarted only when configured
+	policy := NeverRestart
+	if lifecycle {
+		policy = OnFailureRestart
+	}
+	s := &supervised{
+		task:    t,
+		ctx:     ctx,
+		policy:  policy,
+		restart: defaultRestart}
+	if ctx.Config != nil && ctx.Config.Exist("restart") {
+		s.policy = ctx.Config.GetStringOr("restart.policy", policy)
+		s.restart = NewSafeParams(ctx.Config.Get("restart"), defaultRestart)
+	}
+	if !lifecycle && s.policy == AlwaysRestart {
+		logger.Log.Warnf("%s: restart policy %s requires Lifecycle task, %s is used",
+			ctx.Name, AlwaysRestart, OnFailureRestart)
+		s.policy = OnFailureRestart
+	}
+	p.lock.Lock()
+	p.tasks[ctx.Name] = s
+	p.lock.Unlock()
+	p.set(s, StartingState, nil)
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.run(s)
+	}()
+}
+
+// run calls Init until it succeeds and then Run, both are retried by
+// restart policy.
+func (p *Supervisor) run(s *supervised) {
+	err := s.restart.DoContext(s.ctx.Ctx, func(attempt int) error {
+		if attempt > 1 {
+			p.lock.Lock()
+			s.state.Restarts++
+			p.lock.Unlock()
+			logger.Log.Infof("%s: restart %d", s.ctx.Name, attempt-1)
+		}
+		var err error
+		if err = p.init(s); err == nil {
+			p.set(s, RunningState, nil)
+			err = s.task.Run(p.context(s))
+		}
+		if s.ctx.Ctx.Err() != nil {
+			return nil
+		}
+		switch {
+		case err != nil && s.policy == NeverRestart:
+			return Permanent(err)
+		case err == nil && s.policy == AlwaysRestart:
+			return fmt.Errorf("%s: exited", s.ctx.Name)
+		case err != nil:
+			p.set(s, FailedState, err)
+		}
+		return err
+	})
+	switch {
+	case err != nil:
+		p.set(s, FailedState, err)
+	case s.ctx.Ctx.Err() != nil:
+		p.set(s, StoppedState, nil)
+	}
+}
+
+func (p *Supervisor) init(s *supervised) error {
+	l, ok := s.task.(Lifecycle)
+	p.lock.Lock()
+	inited := s.inited
+	p.lock.Unlock()
+	if !ok || inited {
+		return nil
+	}
+	if err := l.Init(p.context(s)); err != nil {
+		return fmt.Errorf("init: %v", err)
+	}
+	p.lock.Lock()
+	s.inited = true
+	p.lock.Unlock()
+	return nil
+}
+
+func (p *Supervisor) context(s *supervised) Context {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return s.ctx
+}
+
+// Reload passes changed config sections to tasks by instance name.
+// Task failed to apply section keeps the old one, tasks without
+// Reloader, changed startSections and added or removed instances
+// require restart.
+func (p *Supervisor) Reload(sections map[string]config.ConfigData) error {
+	p.lock.Lock()
+	tasks := make([]*supervised, 0, len(p.tasks))
+	for name, s := range p.tasks {
+		if _, ok := sections[name]; !ok {
+			logger.Log.Warnf("%s: removed from config, restart required", name)
+			continue
+		}
+		tasks = append(tasks, s)
+	}
+	for name := range sections {
+		if _, ok := p.tasks[name]; !ok {
+			logger.Log.Warnf("%s: added to config, restart required", name)
+		}
+	}
+	p.lock.Unlock()
+
+	errs := make(Errors, 0)
+	for _, s := range tasks {
+		ctx := p.context(s)
+		cfg := sections[ctx.Name]
+		if ctx.Config != nil && ctx.Config.String() == cfg.String() {
+			continue
+		}
+		r, ok := s.task.(Reloader)
+		if !ok {
+			logger.Log.Warnf("%s: config changed, restart required", ctx.Name)
+			continue
+		}
+		if ctx.Config != nil {
+			for _, name := range startSections {
+				if ctx.Config.Get(name).String() != cfg.Get(name).String() {
+					logger.Log.Warnf("%s: %s changed, restart required", ctx.Name, name)
+				}
+			}
+		}
+		ctx.Config = cfg
+		if err := r.Reload(ctx); err != nil {
+			logger.Log.Errorf("%s: reload: %v", ctx.Name, err)
+			errs = append(errs, fmt.Errorf("%s: %v", ctx.Name, err))
+			continue
+		}
+		p.lock.Lock()
+		s.ctx.Config = cfg
+		p.lock.Unlock()
+		logger.Log.Infof("%s: config reloaded", ctx.Name)
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+func (p *Supervisor) set(s *supervised, state string, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	s.state.Name, s.state.State, s.state.Since, s.state.Error = s.ctx.Name, state, time.Now(), ""
+	if err != nil {
+		s.state.Error = err.Error()
+		logger.Log.Errorf("%s: %s: %v", s.ctx.Name, state, err)
+	} else {
+		logger.Log.Debugf("%s: %s", s.ctx.Name, state)
+	}
+}
+
+// States returns states of tasks sorted by name.
+func (p *Supervisor) States() []TaskState {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	states := make([]TaskState, 0, len(p.tasks))
+	for _, s := range p.tasks {
+		state := s.state
+		if l, ok := s.task.(Lifecycle); ok && state.State == RunningState {
+			if err := l.Health(); err != nil {
+				state.Health = err.Error()
+			}
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Name < states[j].Name
+	})
+	return states
+}
+
+// Stop calls Stop of lifecycle tasks and waits for Run of all tasks
+// until ctx is done. Ctx of tasks must be cancelled before.
+func (p *Supervisor) Stop(ctx context.Context) error {
+	p.lock.Lock()
+	tasks := make([]*supervised, 0, len(p.tasks))
+	for _, s := range p.tasks {
+		if s.inited {
+			tasks = append(tasks, s)
+		}
+	}
+	p.lock.Unlock()
+
+	for _, s := range tasks {
+		if l, ok := s.task.(Lifecycle); ok {
+			if err := l.Stop(p.context(s)); err != nil {
+				logger.Log.Errorf("%s: stop: %v", s.ctx.Name, err)
+			}
+		}
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("tasks not stopped: %v", ctx.Err())
+	}
+}
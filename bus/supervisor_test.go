@@ -0,0 +1,243 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+)
+
+type testTask struct {
+	lock      sync.Mutex
+	inits     int
+	runs      int
+	stops     int
+	reloads   int
+	err       error
+	reloadErr error
+	initFails int
+	block     bool
+}
+
+type runFunc func(ctx Context) error
+
+func (f runFunc) Run(ctx Context) error {
+	return f(ctx)
+}
+
+type testSection struct {
+	config.ConfigData
+	value string
+}
+
+func (p testSection) String() string {
+	return p.value
+}
+
+func (p *testTask) Init(ctx Context) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.inits++
+	if p.inits <= p.initFails {
+		return fmt.Errorf("init failed")
+	}
+	return nil
+}
+
+func (p *testTask) Run(ctx Context) error {
+	p.lock.Lock()
+	p.runs++
+	err := p.err
+	p.lock.Unlock()
+	if p.block {
+		<-ctx.Ctx.Done()
+	}
+	return err
+}
+
+func (p *testTask) Stop(ctx Context) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.stops++
+	return nil
+}
+
+func (p *testTask) Reload(ctx Context) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.reloadErr != nil {
+		return p.reloadErr
+	}
+	p.reloads++
+	return nil
+}
+
+func (p *testTask) Health() error {
+	return fmt.Errorf("unhealthy")
+}
+
+func (p *testTask) counts() (int, int, int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.inits, p.runs, p.stops
+}
+
+func TestSupervisor(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("/tmp", "config_")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	cfg := config.New(tmpfile.Name(), config.YAMLAdapter)
+	logger.New(cfg.Get("logger"))
+
+	wait := func(s *Supervisor, state string) TaskState {
+		for i := 0; i < 100; i++ {
+			if states := s.States(); len(states) == 1 && states[0].State == state {
+				return states[0]
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Errorf("state %v != %s", s.States(), state)
+		return TaskState{}
+	}
+
+	t.Run("Restart", func(t *testing.T) {
+		saved := defaultRestart
+		defer func() { defaultRestart = saved }()
+		defaultRestart = SafeParams{Retry: 2, Delay: time.Millisecond}
+
+		task := &testTask{err: fmt.Errorf("failed")}
+		s := NewSupervisor()
+		s.Start(task, Context{Ctx: context.Background(), Name: "test", Config: cfg.Get("test")})
+
+		for i := 0; i < 100; i++ {
+			if _, runs, _ := task.counts(); runs == 3 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		time.Sleep(10 * time.Millisecond)
+		state := wait(s, FailedState)
+		if inits, runs, _ := task.counts(); inits != 1 || runs != 3 || state.Restarts != 2 {
+			t.Errorf("inits %d, runs %d, restarts %d", inits, runs, state.Restarts)
+		}
+	})
+
+	t.Run("InitRestart", func(t *testing.T) {
+		saved := defaultRestart
+		defer func() { defaultRestart = saved }()
+		defaultRestart = SafeParams{Retry: 2, Delay: time.Millisecond}
+
+		task := &testTask{initFails: 1, block: true}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s := NewSupervisor()
+		s.Start(task, Context{Ctx: ctx, Name: "test", Config: cfg.Get("test")})
+
+		state := wait(s, RunningState)
+		if inits, runs, _ := task.counts(); inits != 2 || runs != 1 || state.Restarts != 1 {
+			t.Errorf("inits %d, runs %d, restarts %d", inits, runs, state.Restarts)
+		}
+	})
+
+	t.Run("NeverWithoutLifecycle", func(t *testing.T) {
+		saved := defaultRestart
+		defer func() { defaultRestart = saved }()
+		defaultRestart = SafeParams{Retry: 2, Delay: time.Millisecond}
+
+		runs := 0
+		s := NewSupervisor()
+		s.Start(runFunc(func(ctx Context) error {
+			runs++
+			return fmt.Errorf("failed")
+		}), Context{Ctx: context.Background(), Name: "test", Config: cfg.Get("test")})
+		s.wg.Wait()
+		if state := wait(s, FailedState); runs != 1 || state.Restarts != 0 {
+			t.Errorf("runs %d, restarts %d", runs, state.Restarts)
+		}
+	})
+
+	t.Run("AlwaysWithoutLifecycle", func(t *testing.T) {
+		section, err := ioutil.TempFile("/tmp", "config_")
+		if err != nil {
+			t.Fatal(err)
+		}
+		section.WriteString("test:\n  restart:\n    policy: always\n    retry: 2\n    delay: 1ms\n")
+		section.Close()
+		defer os.Remove(section.Name())
+		c, err := config.Load(section.Name(), config.YAMLAdapter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		runs := 0
+		s := NewSupervisor()
+		s.Start(runFunc(func(ctx Context) error {
+			runs++
+			return nil
+		}), Context{Ctx: context.Background(), Name: "test", Config: c.Get("test")})
+		s.wg.Wait()
+		if runs != 1 {
+			t.Errorf("runs %d != 1", runs)
+		}
+	})
+
+	t.Run("Stop", func(t *testing.T) {
+		task := &testTask{block: true}
+		ctx, cancel := context.WithCancel(context.Background())
+		s := NewSupervisor()
+		s.Start(task, Context{Ctx: ctx, Name: "test", Config: cfg.Get("test")})
+
+		if state := wait(s, RunningState); state.Health != "unhealthy" {
+			t.Errorf("health %s", state.Health)
+		}
+		cancel()
+		stop, done := context.WithTimeout(context.Background(), time.Second)
+		defer done()
+		if err := s.Stop(stop); err != nil {
+			t.Error(err)
+		}
+		wait(s, StoppedState)
+		if _, runs, stops := task.counts(); runs != 1 || stops != 1 {
+			t.Errorf("runs %d, stops %d", runs, stops)
+		}
+	})
+	t.Run("Reload", func(t *testing.T) {
+		task := &testTask{block: true}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s := NewSupervisor()
+		s.Start(task, Context{Ctx: ctx, Name: "test", Config: testSection{cfg.Get("test"), "old"}})
+		wait(s, RunningState)
+
+		if err := s.Reload(map[string]config.ConfigData{"test": testSection{cfg.Get("test"), "old"}}); err != nil || task.reloads != 0 {
+			t.Errorf("reload of unchanged section: %v, reloads %d", err, task.reloads)
+		}
+		if err := s.Reload(map[string]config.ConfigData{"test": testSection{cfg.Get("test"), "new"}}); err != nil || task.reloads != 1 {
+			t.Errorf("reload: %v, reloads %d", err, task.reloads)
+		}
+		if s.context(s.tasks["test"]).Config.String() != "new" {
+			t.Error("section not replaced")
+		}
+
+		task.lock.Lock()
+		task.reloadErr = fmt.Errorf("invalid")
+		task.lock.Unlock()
+		if err := s.Reload(map[string]config.ConfigData{"test": testSection{cfg.Get("test"), "broken"}}); err == nil {
+			t.Error("no error of rejected section")
+		}
+		if s.context(s.tasks["test"]).Config.String() != "new" {
+			t.Error("rejected section replaced")
+		}
+	})
+}
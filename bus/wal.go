@@ -0,0 +1,470 @@
+package bus
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+)
+
+func init() {
+	registry("wal", adapter(&walAdapter{}))
+}
+
+//config section
+//
+//bus:
+//  wal:
+//    event-types:
+//      - "^SERVE$"
+//      - "^OUTDATED$"
+//    path: /var/lib/broforce/wal
+//    compact-every: 1000
+//    max-age: 168h
+//
+
+const (
+	defaultWalPath         = "wal"
+	defaultWalCompactEvery = 1000
+	defaultWalMaxAge       = 7 * 24 * time.Hour
+	walEventsFile          = "events.log"
+	walAcksFile            = "acks.log"
+	walCheckpointFile      = "checkpoint.json"
+)
+
+type walRecord struct {
+	Offset uint64    `json:"offset"`
+	Time   time.Time `json:"time"`
+	Event  Event     `json:"event"`
+}
+
+type walAck struct {
+	Subscriber string `json:"subscriber"`
+	Offset     uint64 `json:"offset"`
+}
+
+// walCheckpoint is state of the log saved on compaction: the last offset
+// and registered subscribers with offset up to which they owe nothing.
+type walCheckpoint struct {
+	Offset      uint64                   `json:"offset"`
+	Subscribers map[string]walSubscriber `json:"subscribers"`
+}
+
+type walSubscriber struct {
+	Subject    string `json:"subject"`
+	Checkpoint uint64 `json:"checkpoint"`
+}
+
+// walAdapter appends every published event to an on-disk log and keeps
+// per-subscriber acknowledgements, so events that were not handled
+// successfully are delivered again after restart. Subscriber gets
+// again only events published after it was registered. The log is
+// compacted on start and every compactEvery events: events handled
+// by all subscribers or older than maxAge are removed.
+type walAdapter struct {
+	subs         map[string]map[uint64]Context
+	seq          uint64
+	lock         sync.Mutex
+	path         string
+	compactEvery int
+	maxAge       time.Duration
+	events       *os.File
+	acks         *os.File
+	offset       uint64
+	appended     int
+	backlog      []walRecord
+	acked        map[string]map[uint64]bool
+	registered   map[string]walSubscriber
+}
+
+func (p *walAdapter) Run(cfg config.ConfigData) error {
+	p.compactEvery = cfg.GetIntOr("compact-every", defaultWalCompactEvery)
+	p.maxAge = getDuration(cfg, "max-age", defaultWalMaxAge)
+	return p.open(cfg.GetStringOr("path", defaultWalPath))
+}
+
+func (p *walAdapter) open(path string) error {
+	p.lock = sync.Mutex{}
+	p.path = path
+	p.subs = make(map[string]map[uint64]Context)
+	p.acked = make(map[string]map[uint64]bool)
+	p.registered = make(map[string]walSubscriber)
+	p.backlog = make([]walRecord, 0)
+
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	if err := p.openLogs(); err != nil {
+		return err
+	}
+	if err := p.load(); err != nil {
+		p.close()
+		return err
+	}
+	if err := p.compact(); err != nil {
+		p.close()
+		return err
+	}
+	return nil
+}
+
+func (p *walAdapter) openLogs() error {
+	var err error
+	if p.events, err = os.OpenFile(filepath.Join(p.path, walEventsFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+		return err
+	}
+	if p.acks, err = os.OpenFile(filepath.Join(p.path, walAcksFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+		p.events.Close()
+		return err
+	}
+	return nil
+}
+
+func (p *walAdapter) close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if err := p.events.Close(); err != nil {
+		p.acks.Close()
+		return err
+	}
+	return p.acks.Close()
+}
+
+func (p *walAdapter) load() error {
+	data, err := ioutil.ReadFile(filepath.Join(p.path, walCheckpointFile))
+	switch {
+	case err == nil:
+		cp := walCheckpoint{}
+		if err := json.Unmarshal(data, &cp); err != nil {
+			return fmt.Errorf("wal: %s: %v", walCheckpointFile, err)
+		}
+		p.offset = cp.Offset
+		for key, sub := range cp.Subscribers {
+			p.registered[key] = sub
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	if err := readLines(p.acks, func(line []byte) error {
+		ack := walAck{}
+		if err := json.Unmarshal(line, &ack); err != nil {
+			return err
+		}
+		p.ack(ack)
+		if _, ok := p.registered[ack.Subscriber]; !ok {
+			// log written before checkpoints: subscriber owes all events
+			subject := ack.Subscriber
+			if i := strings.Index(subject, "/"); i >= 0 {
+				subject = subject[:i]
+			}
+			p.registered[ack.Subscriber] = walSubscriber{Subject: subject}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return readLines(p.events, func(line []byte) error {
+		record := walRecord{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			return err
+		}
+		if record.Offset > p.offset {
+			p.offset = record.Offset
+		}
+		p.backlog = append(p.backlog, record)
+		return nil
+	})
+}
+
+func (p *walAdapter) ack(ack walAck) {
+	if _, ok := p.acked[ack.Subscriber]; !ok {
+		p.acked[ack.Subscriber] = make(map[uint64]bool)
+	}
+	p.acked[ack.Subscriber][ack.Offset] = true
+}
+
+func (p *walAdapter) owes(key string, record walRecord) bool {
+	sub := p.registered[key]
+	return record.Offset > sub.Checkpoint &&
+		MatchSubject(sub.Subject, record.Event.Subject) &&
+		!p.acked[key][record.Offset]
+}
+
+// compact rewrites the log keeping only events some subscriber owes and
+// saves checkpoint, must be called with lock held or before the adapter
+// is used.
+func (p *walAdapter) compact() error {
+	keep := make([]walRecord, 0)
+	checkpoints := make(map[string]uint64)
+	for key := range p.registered {
+		checkpoints[key] = p.offset
+	}
+	for _, record := range p.backlog {
+		owed := make([]string, 0)
+		for key := range p.registered {
+			if p.owes(key, record) {
+				owed = append(owed, key)
+			}
+		}
+		if len(owed) == 0 {
+			continue
+		}
+		if p.maxAge > 0 && !record.Time.IsZero() && time.Since(record.Time) > p.maxAge {
+			logger.Log.Warnf("wal: drop expired event %d %s", record.Offset, record.Event.Subject)
+			continue
+		}
+		for _, key := range owed {
+			if record.Offset-1 < checkpoints[key] {
+				checkpoints[key] = record.Offset - 1
+			}
+		}
+		keep = append(keep, record)
+	}
+
+	cp := walCheckpoint{Offset: p.offset, Subscribers: make(map[string]walSubscriber)}
+	acks := make([]walAck, 0)
+	acked := make(map[string]map[uint64]bool)
+	for key, sub := range p.registered {
+		sub.Checkpoint = checkpoints[key]
+		cp.Subscribers[key] = sub
+		for _, record := range keep {
+			if record.Offset > sub.Checkpoint && p.acked[key][record.Offset] {
+				acks = append(acks, walAck{Subscriber: key, Offset: record.Offset})
+				if _, ok := acked[key]; !ok {
+					acked[key] = make(map[uint64]bool)
+				}
+				acked[key][record.Offset] = true
+			}
+		}
+	}
+
+	if err := writeFile(filepath.Join(p.path, walCheckpointFile), cp); err != nil {
+		return err
+	}
+	events := make([]interface{}, 0, len(keep))
+	for _, record := range keep {
+		events = append(events, record)
+	}
+	if err := writeLines(filepath.Join(p.path, walEventsFile), events); err != nil {
+		return err
+	}
+	lines := make([]interface{}, 0, len(acks))
+	for _, ack := range acks {
+		lines = append(lines, ack)
+	}
+	if err := writeLines(filepath.Join(p.path, walAcksFile), lines); err != nil {
+		return err
+	}
+
+	p.events.Close()
+	p.acks.Close()
+	if err := p.openLogs(); err != nil {
+		return err
+	}
+	p.backlog, p.acked, p.registered, p.appended = keep, acked, cp.Subscribers, 0
+	return nil
+}
+
+// register makes subscriber key owe events published from now on.
+func (p *walAdapter) register(key string, subject string) walSubscriber {
+	if sub, ok := p.registered[key]; ok {
+		return sub
+	}
+	sub := walSubscriber{Subject: subject, Checkpoint: p.offset}
+	p.registered[key] = sub
+	cp := walCheckpoint{Offset: p.offset, Subscribers: p.registered}
+	if err := writeFile(filepath.Join(p.path, walCheckpointFile), cp); err != nil {
+		logger.Log.Errorf("wal: register %s: %v", key, err)
+	}
+	return sub
+}
+
+func writeFile(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func writeLines(path string, values []interface{}) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, v := range values {
+		data, err := json.Marshal(v)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(append(data, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readLines(f *os.File, fn func(line []byte) error) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) != 0 {
+				logger.Log.Errorf("wal: skip incomplete record in %s", f.Name())
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(line); err != nil {
+			logger.Log.Errorf("wal: skip broken record in %s: %v", f.Name(), err)
+		}
+	}
+}
+
+func (p *walAdapter) append(f *os.File, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+type walDelivery struct {
+	subject string
+	ctx     Context
+}
+
+func (p *walAdapter) Publish(e Event) error {
+	p.lock.Lock()
+	offset := p.offset + 1
+	record := walRecord{Offset: offset, Time: time.Now(), Event: e}
+	if err := p.append(p.events, record); err != nil {
+		p.lock.Unlock()
+		return fmt.Errorf("wal: write event %s: %v", e.Subject, err)
+	}
+	p.offset = offset
+	p.backlog = append(p.backlog, record)
+	if p.appended++; p.compactEvery > 0 && p.appended >= p.compactEvery {
+		if err := p.compact(); err != nil {
+			logger.Log.Errorf("wal: compact: %v", err)
+		}
+	}
+
+	deliveries := make([]walDelivery, 0)
+	for subject, subs := range p.subs {
+		if !MatchSubject(subject, e.Subject) {
+			continue
+		}
+		for _, ctx := range subs {
+			deliveries = append(deliveries, walDelivery{subject: subject, ctx: ctx})
+		}
+	}
+	p.lock.Unlock()
+
+	errs := make(Errors, 0)
+	for _, d := range deliveries {
+		d := d
+		if err := dispatch(e, d.ctx, func() { p.deliver(d.subject, offset, e, d.ctx) }); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+func (p *walAdapter) deliver(subject string, offset uint64, e Event, ctx Context) {
+	// dead-lettered event is republished from the dead-letter store
+	if err := ctx.Func(e, ctx); err != nil && !IsDeadLettered(err) {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	ack := walAck{Subscriber: walKey(subject, ctx), Offset: offset}
+	if err := p.append(p.acks, ack); err != nil {
+		logger.Log.Errorf("wal: ack %d: %v", offset, err)
+	}
+	p.ack(ack)
+}
+
+func (p *walAdapter) Subscribe(subject string, ctx Context) Subscription {
+	p.lock.Lock()
+
+	subject = Canonical(subject)
+	if _, ok := p.subs[subject]; !ok {
+		p.subs[subject] = make(map[uint64]Context)
+	}
+	ctx.Func = SafeHandler(ctx.Func, ctx.Retry)
+	p.seq++
+	id := p.seq
+	p.subs[subject][id] = ctx
+
+	key := walKey(subject, ctx)
+	p.register(key, subject)
+	redelivery := make([]walRecord, 0)
+	for _, record := range p.backlog {
+		if p.owes(key, record) {
+			redelivery = append(redelivery, record)
+		}
+	}
+	p.lock.Unlock()
+
+	for _, record := range redelivery {
+		record := record
+		logger.Log.Infof("wal: redelivery %d %s to %s", record.Offset, record.Event.Subject, ctx.Name)
+		if err := dispatch(record.Event, ctx, func() { p.deliver(subject, record.Offset, record.Event, ctx) }); err != nil {
+			logger.Log.Errorf("wal: redelivery %d: %v", record.Offset, err)
+		}
+	}
+
+	return newSubscription(func() {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+
+		delete(p.subs[subject], id)
+		if len(p.subs[subject]) == 0 {
+			delete(p.subs, subject)
+		}
+	})
+}
+
+func walKey(subject string, ctx Context) string {
+	return fmt.Sprintf("%s/%s", subject, ctx.Name)
+}
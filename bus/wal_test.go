@@ -0,0 +1,188 @@
+package bus
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+)
+
+func TestWal(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("/tmp", "config_")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	cfg := config.New(tmpfile.Name(), config.YAMLAdapter)
+	logger.New(cfg.Get("logger"))
+
+	dir, err := ioutil.TempDir("/tmp", "wal_")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+	}
+	defer os.RemoveAll(dir)
+
+	// deliveries are counted by bus, so handlers are finished before
+	// adapters are closed and do not outlive the test
+	deliveries := &EventsBus{}
+
+	t.Run("Redelivery", func(t *testing.T) {
+		failed := int32(0)
+		a := &walAdapter{}
+		if err := a.open(dir); err != nil {
+			t.Fatal(err)
+		}
+		a.Subscribe(ServeCmdEvent, Context{
+			Bus:  deliveries,
+			Name: "TestHandler",
+			Log:  logger.Logger4Handler("TestHandler", ""),
+			Func: func(e Event, ctx Context) error {
+				atomic.AddInt32(&failed, 1)
+				return fmt.Errorf("handler failed")
+			}})
+		if err := a.Publish(Event{Subject: ServeCmdEvent, Coding: JsonCoding, Data: []byte(`{}`)}); err != nil {
+			t.Error(err)
+		}
+		waitDeliveries(t, deliveries)
+		a.close()
+
+		if atomic.LoadInt32(&failed) != 1 {
+			t.Errorf("failed %d != 1", failed)
+		}
+
+		got := int32(0)
+		b := &walAdapter{}
+		if err := b.open(dir); err != nil {
+			t.Fatal(err)
+		}
+		b.Subscribe(ServeCmdEvent, Context{
+			Bus:  deliveries,
+			Name: "TestHandler",
+			Log:  logger.Logger4Handler("TestHandler", ""),
+			Func: func(e Event, ctx Context) error {
+				atomic.AddInt32(&got, 1)
+				return nil
+			}})
+		waitDeliveries(t, deliveries)
+		b.close()
+
+		if atomic.LoadInt32(&got) != 1 {
+			t.Errorf("got %d != 1", got)
+		}
+
+		c := &walAdapter{}
+		if err := c.open(dir); err != nil {
+			t.Fatal(err)
+		}
+		defer c.close()
+		c.Subscribe(ServeCmdEvent, Context{
+			Bus:  deliveries,
+			Name: "TestHandler",
+			Log:  logger.Logger4Handler("TestHandler", ""),
+			Func: func(e Event, ctx Context) error {
+				t.Error("acknowledged event delivered again")
+				return nil
+			}})
+		waitDeliveries(t, deliveries)
+
+		if c.offset != 1 {
+			t.Errorf("offset %d != 1", c.offset)
+		}
+	})
+	t.Run("NewSubscriber", func(t *testing.T) {
+		a := &walAdapter{}
+		if err := a.open(dir); err != nil {
+			t.Fatal(err)
+		}
+		a.Subscribe(ServeCmdEvent, Context{
+			Bus:  deliveries,
+			Name: "FailingHandler",
+			Log:  logger.Logger4Handler("FailingHandler", ""),
+			Func: func(e Event, ctx Context) error { return Permanent(fmt.Errorf("handler failed")) }})
+		a.Publish(Event{Subject: ServeCmdEvent, Coding: JsonCoding, Data: []byte(`{}`)})
+		waitDeliveries(t, deliveries)
+		a.close()
+
+		b := &walAdapter{}
+		if err := b.open(dir); err != nil {
+			t.Fatal(err)
+		}
+		defer b.close()
+		b.Subscribe(ServeCmdEvent, Context{
+			Bus:  deliveries,
+			Name: "NewHandler",
+			Log:  logger.Logger4Handler("NewHandler", ""),
+			Func: func(e Event, ctx Context) error {
+				t.Error("event published before registration delivered")
+				return nil
+			}})
+		waitDeliveries(t, deliveries)
+		if len(b.backlog) != 1 {
+			t.Errorf("backlog %d != 1", len(b.backlog))
+		}
+	})
+
+	t.Run("Compact", func(t *testing.T) {
+		dir, err := ioutil.TempDir("/tmp", "wal_")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		a := &walAdapter{compactEvery: 3}
+		if err := a.open(dir); err != nil {
+			t.Fatal(err)
+		}
+		a.Subscribe(ServeCmdEvent, Context{
+			Bus:  deliveries,
+			Name: "TestHandler",
+			Log:  logger.Logger4Handler("TestHandler", ""),
+			Func: func(e Event, ctx Context) error { return nil }})
+		for i := 0; i < 4; i++ {
+			a.Publish(Event{Subject: ServeCmdEvent, Coding: JsonCoding, Data: []byte(`{}`)})
+			waitDeliveries(t, deliveries)
+		}
+		a.close()
+
+		// compacted on the third event, before it was handled
+		if len(a.backlog) != 2 {
+			t.Errorf("backlog %d != 2", len(a.backlog))
+		}
+
+		b := &walAdapter{maxAge: time.Nanosecond}
+		if err := b.open(dir); err != nil {
+			t.Fatal(err)
+		}
+		defer b.close()
+		if len(b.backlog) != 0 || b.offset != 4 {
+			t.Errorf("backlog %d != 0, offset %d != 4", len(b.backlog), b.offset)
+		}
+		if info, err := os.Stat(filepath.Join(dir, walEventsFile)); err != nil || info.Size() != 0 {
+			t.Errorf("events log not compacted: %v", err)
+		}
+		key := walKey(Canonical(ServeCmdEvent), Context{Name: "TestHandler"})
+		if b.registered[key].Checkpoint != 4 {
+			t.Errorf("checkpoint %d != 4", b.registered[key].Checkpoint)
+		}
+	})
+}
+
+func waitDeliveries(t *testing.T, b *EventsBus) {
+	deadline := time.Now().Add(5 * time.Second)
+	for b.InFlight() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d deliveries not finished", b.InFlight())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
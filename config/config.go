@@ -2,25 +2,37 @@ package config
 
 import (
 	"fmt"
+	"log"
 	"sync"
+	"time"
 )
 
 var instance Config
 var once sync.Once
-var configAdapters = make(map[string]Config)
+var configAdapters = make(map[string]func(profile string) Config)
 
-func registry(name string, cfg Config) {
-	configAdapters[name] = cfg
+func registry(name string, factory func(profile string) Config) {
+	configAdapters[name] = factory
 }
 
+// ErrorHandler receives errors of accessors without error result,
+// which return zero value then.
+var ErrorHandler = func(err error) {
+	log.Printf("config: %v", err)
+}
+
+// New returns config of the process, loaded on the first call. Error
+// of loading is passed to ErrorHandler and nil is returned.
 func New(path string, adapter string) Config {
+	return NewProfile(path, adapter, "")
+}
+
+// NewProfile is New with overlay of profile applied.
+func NewProfile(path string, adapter string, profile string) Config {
 	once.Do(func() {
-		instance = configAdapters[adapter]
-		if instance == nil {
-			return
-		}
-		if err := instance.Init(path); err != nil {
-			fmt.Errorf("Error: %v", err)
+		var err error
+		if instance, err = LoadProfile(path, adapter, profile); err != nil {
+			ErrorHandler(err)
 			instance = nil
 			return
 		}
@@ -28,6 +40,26 @@ func New(path string, adapter string) Config {
 	return instance
 }
 
+// Load reads config from path by adapter, unlike New every call
+// returns new config.
+func Load(path string, adapter string) (Config, error) {
+	return LoadProfile(path, adapter, "")
+}
+
+// LoadProfile is Load with overlay of profile applied, empty profile
+// means no overlay.
+func LoadProfile(path string, adapter string, profile string) (Config, error) {
+	factory, ok := configAdapters[adapter]
+	if !ok {
+		return nil, fmt.Errorf("unknown config adapter %s", adapter)
+	}
+	c := factory(profile)
+	if err := c.Init(path); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
 type ConfigData interface {
 	String() string
 	Exist(path string) bool
@@ -41,9 +73,24 @@ type ConfigData interface {
 	GetArray(path string) []ConfigData
 	GetArrayString(path string) []string
 	GetMap(path string) map[string]ConfigData
+
+	// Typed accessors return error when value at path is missing or
+	// has another type, empty path is the data itself.
+	GetBool(path string) (bool, error)
+	GetDuration(path string) (time.Duration, error)
+	GetStringMap(path string) (map[string]string, error)
+	Decode(path string, v interface{}) error
 }
 
 type Config interface {
 	Init(path string) error
+	// Reload re-reads config, new one is applied only if it is parsed
+	// and passes check. ConfigData got before reload is not changed.
+	Reload(check func(Config) error) error
+	Exist(name string) bool
 	Get(name string) ConfigData
+	// Sections returns sorted names of top-level sections.
+	Sections() []string
+	// Files returns files and directories config was read from.
+	Files() []string
 }
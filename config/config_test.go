@@ -1,10 +1,14 @@
 package config
 
 import (
+	"context"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestNew(t *testing.T) {
@@ -17,3 +21,66 @@ func TestNew(t *testing.T) {
 	defer os.Remove(tmpfile.Name())
 	assert.Nil(t, New(tmpfile.Name(), ""))
 }
+
+func TestDefaultConfig_Reload(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("/tmp", "config_")
+	assert.NoError(t, err)
+	tmpfile.WriteString("task:\n  param: old\n")
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	config := defaultConfig{}
+	assert.NoError(t, config.Init(tmpfile.Name()))
+	old := config.Get("task")
+
+	assert.NoError(t, ioutil.WriteFile(tmpfile.Name(), []byte("task: [broken\n"), 0644))
+	assert.Error(t, config.Reload(nil))
+	assert.Equal(t, "old", config.Get("task").GetString("param"))
+
+	assert.NoError(t, ioutil.WriteFile(tmpfile.Name(), []byte("task:\n  param: new\n"), 0644))
+	assert.Error(t, config.Reload(func(c Config) error {
+		return fmt.Errorf("rejected")
+	}))
+	assert.Equal(t, "old", config.Get("task").GetString("param"))
+
+	assert.NoError(t, config.Reload(func(c Config) error {
+		assert.Equal(t, "new", c.Get("task").GetString("param"))
+		return nil
+	}))
+	assert.Equal(t, "new", config.Get("task").GetString("param"))
+	assert.Equal(t, "old", old.GetString("param"))
+}
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "config_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "conf.d"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.yml"), []byte("include: conf.d\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "conf.d", "a.yml"), []byte("a: {}\n"), 0644))
+
+	c, err := Load(filepath.Join(dir, "config.yml"), YAMLAdapter)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(c.Files()))
+
+	changed := make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Watch(ctx, c.Files, 10*time.Millisecond, func() { changed <- struct{}{} })
+
+	wait := func(what string) {
+		select {
+		case <-changed:
+		case <-time.After(time.Second):
+			t.Errorf("change of %s not detected", what)
+		}
+	}
+	time.Sleep(30 * time.Millisecond)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.yml"), []byte("include: conf.d\ntask: {}\n"), 0644))
+	wait("config")
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "conf.d", "a.yml"), []byte("a: {b: 1}\n"), 0644))
+	wait("included file")
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "conf.d", "b.yml"), []byte("b: {}\n"), 0644))
+	wait("included directory")
+}
@@ -1,40 +1,105 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Jeffail/gabs"
 	"github.com/ghodss/yaml"
+	yamlv2 "gopkg.in/yaml.v2"
 )
 
 func init() {
-	registry(YAMLAdapter, Config(&defaultConfig{}))
+	registry(YAMLAdapter, func(profile string) Config { return &defaultConfig{profile: profile} })
 }
 
 type defaultConfig struct {
-	data gabs.Container
-	path string
+	lock    sync.RWMutex
+	data    gabs.Container
+	path    string
+	profile string
+	files   []string
 }
 
 func (p *defaultConfig) Init(path string) error {
-	p.path = path
-	data, err := ioutil.ReadFile(p.path)
+	g, files, err := p.parse(path)
 	if err != nil {
-		return fmt.Errorf("file `%s` not found: %v", p.path, err)
+		return err
 	}
-	if jsonData, err := yaml.YAMLToJSON(data); err != nil {
-		return fmt.Errorf("Error on parse file `%s`: %v!", p.path, err)
-	} else {
-		g, _ := gabs.ParseJSON(jsonData)
-		p.data = *g
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.path, p.data, p.files = path, *g, files
+	return nil
+}
+
+func (p *defaultConfig) parse(path string) (*gabs.Container, []string, error) {
+	root, files, err := load(path, p.profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	override(root, os.Environ())
+	if _, err := expand(root, ""); err != nil {
+		return nil, nil, fmt.Errorf("file `%s`: %v", path, err)
+	}
+	g, err := gabs.Consume(root)
+	return g, files, err
+}
+
+func (p *defaultConfig) Reload(check func(Config) error) error {
+	p.lock.RLock()
+	path := p.path
+	p.lock.RUnlock()
+
+	g, files, err := p.parse(path)
+	if err != nil {
+		return err
 	}
+	if check != nil {
+		if err := check(&defaultConfig{data: *g, path: path, profile: p.profile, files: files}); err != nil {
+			return err
+		}
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.data, p.files = *g, files
 	return nil
 }
 
+func (p *defaultConfig) Files() []string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return append([]string{}, p.files...)
+}
+
+func (p *defaultConfig) Exist(name string) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.data.Exists(name)
+}
+
+func (p *defaultConfig) Sections() []string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	out := make([]string, 0)
+	if m, ok := p.data.Data().(map[string]interface{}); ok {
+		for k := range m {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
 func (p *defaultConfig) Get(name string) ConfigData {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
 	if c := p.data.Search(name); c != nil {
 		return ConfigData(&defaultConfigData{data: c})
 	} else {
@@ -62,16 +127,27 @@ func (p *defaultConfigData) Exist(path string) bool {
 	return p.data.ExistsP(path)
 }
 
-func (p *defaultConfigData) GetString(path string) string {
+// value returns container at path, empty path is the data itself.
+func (p *defaultConfigData) value(path string) *gabs.Container {
 	if len(strings.TrimSpace(path)) == 0 {
-		return p.data.Data().(string)
-	} else {
-		return fmt.Sprintf("%v", p.data.Path(path).Data())
+		return p.data
+	}
+	return p.data.Path(path)
+}
+
+func (p *defaultConfigData) lookup(path string) (interface{}, error) {
+	if len(strings.TrimSpace(path)) != 0 && !p.data.ExistsP(path) {
+		return nil, fmt.Errorf("%s: not found", path)
 	}
+	return p.value(path).Data(), nil
+}
+
+func (p *defaultConfigData) GetString(path string) string {
+	return scalar(p.value(path).Data())
 }
 
 func (p *defaultConfigData) Search(hierarchy ...string) string {
-	return fmt.Sprintf("%v", p.data.Search(hierarchy...).Data())
+	return scalar(p.data.Search(hierarchy...).Data())
 }
 
 func (p *defaultConfigData) GetStringOr(path string, defaultVal string) string {
@@ -84,7 +160,7 @@ func (p *defaultConfigData) GetStringOr(path string, defaultVal string) string {
 
 func (p *defaultConfigData) GetFloat(path string) float64 {
 	if f, err := strconv.ParseFloat(p.GetString(path), 64); err != nil {
-		fmt.Errorf("Error get float: %v", err)
+		ErrorHandler(fmt.Errorf("%s: float expected: %v", path, err))
 		return 0
 	} else {
 		return f
@@ -93,7 +169,7 @@ func (p *defaultConfigData) GetFloat(path string) float64 {
 
 func (p *defaultConfigData) GetInt(path string) int {
 	if i, err := strconv.Atoi(p.GetString(path)); err != nil {
-		fmt.Errorf("Error get int: %v", err)
+		ErrorHandler(fmt.Errorf("%s: int expected: %v", path, err))
 		return 0
 	} else {
 		return i
@@ -108,62 +184,195 @@ func (p *defaultConfigData) GetIntOr(path string, defaultVal int) int {
 	}
 }
 
-func (p *defaultConfigData) GetBool(path string) bool {
-	return strings.ToLower(p.GetString(path)) == "true"
+func (p *defaultConfigData) GetBool(path string) (bool, error) {
+	v, err := p.lookup(path)
+	if err != nil {
+		return false, err
+	}
+	b, err := strconv.ParseBool(scalar(v))
+	if err != nil {
+		return false, fmt.Errorf("%s: bool expected, got %s", path, kind(v))
+	}
+	return b, nil
 }
 
-func (p *defaultConfigData) GetArray(path string) []ConfigData {
-	out := make([]ConfigData, 0)
-	var data *gabs.Container
+// GetDuration accepts duration string (`500ms`, `10s`) or number of
+// seconds.
+func (p *defaultConfigData) GetDuration(path string) (time.Duration, error) {
+	v, err := p.lookup(path)
+	if err != nil {
+		return 0, err
+	}
+	s := scalar(v)
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(f * float64(time.Second)), nil
+	}
+	return 0, fmt.Errorf("%s: duration expected, got %s", path, kind(v))
+}
 
-	if len(strings.TrimSpace(path)) == 0 {
-		data = p.data
-	} else {
-		data = p.data.Path(path)
+func (p *defaultConfigData) GetStringMap(path string) (map[string]string, error) {
+	v, err := p.lookup(path)
+	if err != nil {
+		return nil, err
 	}
-	arr, err := data.Children()
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: map expected, got %s", path, kind(v))
+	}
+	out := make(map[string]string, len(m))
+	for k, item := range m {
+		switch item.(type) {
+		case nil, []interface{}, map[string]interface{}:
+			return nil, fmt.Errorf("%s: string expected, got %s", join(path, k), kind(item))
+		}
+		out[k] = scalar(item)
+	}
+	return out, nil
+}
+
+// Decode unmarshals value at path to v: by yaml tags when struct v
+// has them, by json tags otherwise. Scalars are accepted by string
+// fields as by GetString.
+func (p *defaultConfigData) Decode(path string, v interface{}) error {
+	data, err := p.lookup(path)
 	if err != nil {
-		fmt.Errorf("Error get array `%v` from: %v", path, p.data.Path(path).Data())
-		return out
+		return err
 	}
-	for _, v := range arr {
-		out = append(out, ConfigData(&defaultConfigData{data: v}))
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
 	}
-	return out
+	if hasTag(reflect.TypeOf(v), "yaml") {
+		y, err := yaml.JSONToYAML(js)
+		if err != nil {
+			return err
+		}
+		return wrap(path, yamlv2.Unmarshal(y, v))
+	}
+	if js, err = json.Marshal(coerce(data, reflect.TypeOf(v))); err != nil {
+		return err
+	}
+	return wrap(path, json.Unmarshal(js, v))
 }
 
-func (p *defaultConfigData) GetArrayString(path string) []string {
-	out := make([]string, 0)
-	var data *gabs.Container
+func wrap(path string, err error) error {
+	if err == nil || len(strings.TrimSpace(path)) == 0 {
+		return err
+	}
+	return fmt.Errorf("%s: %v", path, err)
+}
 
-	if len(strings.TrimSpace(path)) == 0 {
-		data = p.data
-	} else {
-		data = p.data.Path(path)
+// coerce converts numbers and bools of data to strings where type t
+// expects strings.
+func coerce(data interface{}, t reflect.Type) interface{} {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return data
+	}
+	switch t.Kind() {
+	case reflect.String:
+		switch data.(type) {
+		case float64, bool:
+			return scalar(data)
+		}
+	case reflect.Slice, reflect.Array:
+		if l, ok := data.([]interface{}); ok {
+			out := make([]interface{}, len(l))
+			for i, item := range l {
+				out[i] = coerce(item, t.Elem())
+			}
+			return out
+		}
+	case reflect.Map:
+		if m, ok := data.(map[string]interface{}); ok {
+			out := make(map[string]interface{}, len(m))
+			for k, item := range m {
+				out[k] = coerce(item, t.Elem())
+			}
+			return out
+		}
+	case reflect.Struct:
+		if m, ok := data.(map[string]interface{}); ok {
+			out := make(map[string]interface{}, len(m))
+			for k, item := range m {
+				if f, ok := jsonField(t, k); ok {
+					item = coerce(item, f.Type)
+				}
+				out[k] = item
+			}
+			return out
+		}
+	}
+	return data
+}
+
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func hasTag(t reflect.Type, tag string) bool {
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Map) {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if _, ok := t.Field(i).Tag.Lookup(tag); ok {
+			return true
+		}
 	}
-	arr, err := data.Children()
+	return false
+}
+
+func (p *defaultConfigData) GetArray(path string) []ConfigData {
+	out := make([]ConfigData, 0)
+	arr, err := p.value(path).Children()
 	if err != nil {
-		fmt.Errorf("Error get array `%v` from: %v", path, p.data.Path(path).Data())
+		if p.data.ExistsP(path) || len(strings.TrimSpace(path)) == 0 && p.data.Data() != nil {
+			ErrorHandler(fmt.Errorf("%s: list expected, got %s", path, kind(p.value(path).Data())))
+		}
 		return out
 	}
 	for _, v := range arr {
-		out = append(out, v.Data().(string))
+		out = append(out, ConfigData(&defaultConfigData{data: v}))
+	}
+	return out
+}
+
+func (p *defaultConfigData) GetArrayString(path string) []string {
+	out := make([]string, 0)
+	for _, v := range p.GetArray(path) {
+		out = append(out, v.GetString(""))
 	}
 	return out
 }
 
 func (p *defaultConfigData) GetMap(path string) map[string]ConfigData {
 	out := make(map[string]ConfigData)
-	var data *gabs.Container
-
-	if len(strings.TrimSpace(path)) == 0 {
-		data = p.data
-	} else {
-		data = p.data.Path(path)
-	}
-	mmap, err := data.ChildrenMap()
+	mmap, err := p.value(path).ChildrenMap()
 	if err != nil {
-		fmt.Errorf("Error get map '%v' from: %v. Error: %s", path, p.data.Path(path).Data(), err)
+		if p.data.ExistsP(path) || len(strings.TrimSpace(path)) == 0 && p.data.Data() != nil {
+			ErrorHandler(fmt.Errorf("%s: map expected, got %s", path, kind(p.value(path).Data())))
+		}
 		return out
 	}
 
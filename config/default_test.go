@@ -3,7 +3,9 @@ package config
 import (
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -228,3 +230,129 @@ slackSensor:
 	configData := config.Get("task1").Get("param1")
 	assert.Equal(t, configData.GetArrayString(""), []string{"value1", "value2"})
 }
+
+func TestDefaultConfig_Exist(t *testing.T) {
+	data := `timeSensor:
+  interval: 10
+
+slackSensor: {}
+`
+	tmpfile, err := ioutil.TempFile("/tmp", "manifest_")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+	}
+	defer os.Remove(tmpfile.Name())
+	if _, err := tmpfile.Write([]byte(data)); err != nil {
+		t.Error(err)
+		t.Fail()
+	}
+	tmpfile.Close()
+	config := defaultConfig{}
+	config.Init(tmpfile.Name())
+
+	assert.Equal(t, config.Exist("timeSensor"), true)
+	assert.Equal(t, config.Exist("slackSensor"), true)
+	assert.Equal(t, config.Exist("hookSensor"), false)
+}
+
+func TestDefaultConfigData_Typed(t *testing.T) {
+	data := `task:
+  enabled: true
+  broken: maybe
+  timeout: 10s
+  delay: 1.5
+  chat_id: 1000000
+  labels:
+    team: ops
+    port: 8080
+  nested:
+    labels:
+      team: [ops]
+  pipeline:
+    name: deploy
+    ref: master
+    stages: [build, test]
+`
+	tmpfile, err := ioutil.TempFile("/tmp", "manifest_")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+	}
+	defer os.Remove(tmpfile.Name())
+	if _, err := tmpfile.Write([]byte(data)); err != nil {
+		t.Error(err)
+		t.Fail()
+	}
+	tmpfile.Close()
+	config := defaultConfig{}
+	assert.NoError(t, config.Init(tmpfile.Name()))
+	task := config.Get("task")
+
+	b, err := task.GetBool("enabled")
+	assert.NoError(t, err)
+	assert.True(t, b)
+	_, err = task.GetBool("broken")
+	assert.EqualError(t, err, `broken: bool expected, got "maybe"`)
+	_, err = task.GetBool("missing")
+	assert.EqualError(t, err, "missing: not found")
+
+	d, err := task.GetDuration("timeout")
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Second, d)
+	d, err = task.GetDuration("delay")
+	assert.NoError(t, err)
+	assert.Equal(t, 1500*time.Millisecond, d)
+	_, err = task.GetDuration("broken")
+	assert.Error(t, err)
+
+	assert.Equal(t, 1000000, task.GetInt("chat_id"))
+	assert.Equal(t, "", task.GetString("missing"))
+
+	m, err := task.GetStringMap("labels")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "ops", "port": "8080"}, m)
+	_, err = task.GetStringMap("nested.labels")
+	assert.EqualError(t, err, "nested.labels.team: string expected, got list")
+	_, err = task.GetStringMap("enabled")
+	assert.Error(t, err)
+
+	byJSON := struct {
+		Name   string   `json:"name"`
+		Ref    string   `json:"ref"`
+		Stages []string `json:"stages"`
+	}{}
+	assert.NoError(t, task.Decode("pipeline", &byJSON))
+	assert.Equal(t, "deploy", byJSON.Name)
+	assert.Equal(t, []string{"build", "test"}, byJSON.Stages)
+
+	byYAML := struct {
+		Pipeline struct {
+			Name string `yaml:"name"`
+		} `yaml:"pipeline"`
+		Timeout string `yaml:"timeout"`
+	}{}
+	assert.NoError(t, task.Decode("", &byYAML))
+	assert.Equal(t, "deploy", byYAML.Pipeline.Name)
+	assert.Equal(t, "10s", byYAML.Timeout)
+
+	assert.Error(t, task.Decode("pipeline.stages", &byJSON))
+	assert.EqualError(t, task.Decode("missing", &byJSON), "missing: not found")
+
+	scalars := struct {
+		ChatID  string            `json:"chat_id"`
+		Enabled string            `json:"enabled"`
+		Labels  map[string]string `json:"labels"`
+	}{}
+	assert.NoError(t, task.Decode("", &scalars))
+	assert.Equal(t, "1000000", scalars.ChatID)
+	assert.Equal(t, "true", scalars.Enabled)
+	assert.Equal(t, "8080", scalars.Labels["port"])
+
+	wrong := struct {
+		Enabled int `json:"enabled"`
+	}{}
+	err = task.Decode("", &wrong)
+	assert.Error(t, err)
+	assert.False(t, strings.HasPrefix(err.Error(), ":"))
+}
@@ -0,0 +1,229 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+//config section
+//
+//include:
+//  - conf.d
+//  - secrets/*.yml
+//
+//profiles:
+//  production:
+//    include: production.yml
+//    bus:
+//      drain-timeout: 60
+//
+
+const (
+	includeKey  = "include"
+	profilesKey = "profiles"
+	maskValue   = "******"
+)
+
+var secretKey = regexp.MustCompile(`(?i)(token|password|secret|api-key|auth-key-value)`)
+
+// loader reads config files: seen guards against recursive includes,
+// files are files and directories read, for watching.
+type loader struct {
+	seen  map[string]bool
+	files []string
+}
+
+// load reads yaml file at path with files of its `include` merged and
+// overlay of profile applied, files read are returned too.
+func load(path string, profile string) (map[string]interface{}, []string, error) {
+	l := &loader{seen: make(map[string]bool)}
+	root, err := l.file(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	profiles, _ := root[profilesKey].(map[string]interface{})
+	delete(root, profilesKey)
+	if len(profile) == 0 {
+		return root, l.files, nil
+	}
+	overlay, ok := profiles[profile].(map[string]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("profile `%s` not found in `%s`", profile, path)
+	}
+	if overlay, err = l.resolve(overlay, filepath.Dir(path)); err != nil {
+		return nil, nil, fmt.Errorf("profile `%s`: %v", profile, err)
+	}
+	return merge(root, overlay), l.files, nil
+}
+
+func (p *loader) watch(path string) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return
+	}
+	for _, f := range p.files {
+		if f == abs {
+			return
+		}
+	}
+	p.files = append(p.files, abs)
+}
+
+func (p *loader) file(path string) (map[string]interface{}, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if p.seen[abs] {
+		return nil, fmt.Errorf("file `%s` included recursively", path)
+	}
+	p.seen[abs] = true
+	defer delete(p.seen, abs)
+	p.watch(abs)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("file `%s` not found: %v", path, err)
+	}
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("Error on parse file `%s`: %v!", path, err)
+	}
+	if v == nil {
+		return make(map[string]interface{}), nil
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("file `%s`: map expected", path)
+	}
+	return p.resolve(m, filepath.Dir(path))
+}
+
+// resolve merges files of `include` of m under m, patterns are
+// relative to dir.
+func (p *loader) resolve(m map[string]interface{}, dir string) (map[string]interface{}, error) {
+	include, ok := m[includeKey]
+	if !ok {
+		return m, nil
+	}
+	delete(m, includeKey)
+
+	patterns := make([]string, 0)
+	switch t := include.(type) {
+	case string:
+		patterns = append(patterns, t)
+	case []interface{}:
+		for _, item := range t {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: string expected", includeKey)
+			}
+			patterns = append(patterns, s)
+		}
+	default:
+		return nil, fmt.Errorf("%s: string or list expected", includeKey)
+	}
+
+	base := make(map[string]interface{})
+	for _, pattern := range patterns {
+		files, err := p.expand(dir, pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			inc, err := p.file(f)
+			if err != nil {
+				return nil, err
+			}
+			base = merge(base, inc)
+		}
+	}
+	return merge(base, m), nil
+}
+
+// expand returns files of pattern: yaml files of directory in name
+// order, files matching glob or the file itself. Directory of directory
+// or glob pattern is watched for added and removed files.
+func (p *loader) expand(dir string, pattern string) ([]string, error) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+	if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+		p.watch(pattern)
+		files := make([]string, 0)
+		for _, ext := range []string{"*.yml", "*.yaml"} {
+			matches, _ := filepath.Glob(filepath.Join(pattern, ext))
+			files = append(files, matches...)
+		}
+		sort.Strings(files)
+		return files, nil
+	}
+	if strings.ContainsAny(pattern, "*?[") {
+		p.watch(filepath.Dir(pattern))
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", includeKey, err)
+		}
+		sort.Strings(files)
+		return files, nil
+	}
+	return []string{pattern}, nil
+}
+
+// merge deep-merges src into dst: maps are merged by keys, other
+// values of src replace values of dst.
+func merge(dst map[string]interface{}, src map[string]interface{}) map[string]interface{} {
+	for k, v := range src {
+		sm, ok := v.(map[string]interface{})
+		dm, ok2 := dst[k].(map[string]interface{})
+		if ok && ok2 {
+			dst[k] = merge(dm, sm)
+			continue
+		}
+		dst[k] = v
+	}
+	return dst
+}
+
+// Dump returns effective config in yaml, values of keys looking like
+// secrets (token, password, secret, api-key, auth-key-value) are masked.
+func Dump(c Config) ([]byte, error) {
+	root := make(map[string]interface{})
+	for _, name := range c.Sections() {
+		var v interface{}
+		if err := yaml.Unmarshal([]byte(c.Get(name).String()), &v); err != nil {
+			return nil, err
+		}
+		root[name] = v
+	}
+	return yaml.Marshal(mask(root))
+}
+
+func mask(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, child := range t {
+			if _, nested := child.(map[string]interface{}); !nested && secretKey.MatchString(k) && child != nil {
+				out[k] = maskValue
+				continue
+			}
+			out[k] = mask(child)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, child := range t {
+			out[i] = mask(child)
+		}
+		return out
+	}
+	return v
+}
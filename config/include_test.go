@@ -0,0 +1,88 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "config_")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name string, data string) {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(data), 0644))
+	}
+	write("config.yml", `
+include:
+  - conf.d
+bus:
+  drain-timeout: 30
+  simple:
+    priority: 1
+profiles:
+  production:
+    include: production.yml
+    bus:
+      drain-timeout: 60
+`)
+	write("conf.d/10-slack.yml", "slackSensor:\n  token: xoxb-1\n  channel: general\n")
+	write("conf.d/20-slack.yml", "slackSensor:\n  channel: ops\n")
+	write("conf.d/readme.txt", "not: included\n")
+	write("production.yml", "bus:\n  simple:\n    priority: 5\n")
+
+	c, err := LoadProfile(filepath.Join(dir, "config.yml"), YAMLAdapter, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bus", "slackSensor"}, c.Sections())
+	assert.Equal(t, "ops", c.Get("slackSensor").GetString("channel"))
+	assert.Equal(t, "xoxb-1", c.Get("slackSensor").GetString("token"))
+	assert.Equal(t, 30, c.Get("bus").GetInt("drain-timeout"))
+	assert.Equal(t, 1, c.Get("bus").GetInt("simple.priority"))
+
+	c, err = LoadProfile(filepath.Join(dir, "config.yml"), YAMLAdapter, "production")
+	assert.NoError(t, err)
+	assert.Equal(t, 60, c.Get("bus").GetInt("drain-timeout"))
+	assert.Equal(t, 5, c.Get("bus").GetInt("simple.priority"))
+	assert.Equal(t, "ops", c.Get("slackSensor").GetString("channel"))
+
+	write("production.yml", "bus:\n  simple:\n    priority: 7\n")
+	assert.NoError(t, c.Reload(nil))
+	assert.Equal(t, 7, c.Get("bus").GetInt("simple.priority"))
+
+	_, err = LoadProfile(filepath.Join(dir, "config.yml"), YAMLAdapter, "staging")
+	assert.Error(t, err)
+
+	out, err := Dump(c)
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), "token: '******'")
+	assert.Contains(t, string(out), "channel: ops")
+	assert.NotContains(t, string(out), "xoxb-1")
+	assert.False(t, strings.Contains(string(out), "profiles"))
+
+	write("loop.yml", "include: loop.yml\n")
+	_, err = Load(filepath.Join(dir, "loop.yml"), YAMLAdapter)
+	assert.Error(t, err)
+
+	write("missing.yml", "include: absent.yml\n")
+	_, err = Load(filepath.Join(dir, "missing.yml"), YAMLAdapter)
+	assert.Error(t, err)
+}
+
+func TestMerge(t *testing.T) {
+	dst := map[string]interface{}{
+		"a": map[string]interface{}{"b": 1, "c": []interface{}{1, 2}},
+		"d": "x"}
+	src := map[string]interface{}{
+		"a": map[string]interface{}{"c": []interface{}{3}, "e": true},
+		"d": map[string]interface{}{"f": "y"}}
+	assert.Equal(t, map[string]interface{}{
+		"a": map[string]interface{}{"b": 1, "c": []interface{}{3}, "e": true},
+		"d": map[string]interface{}{"f": "y"}}, merge(dst, src))
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	envPrefix  = "BROFORCE_"
+	filePrefix = "file:"
+	envDefault = ":-"
+)
+
+var reference = regexp.MustCompile(`\$\$|\$\{([^}]*)\}`)
+
+// interpolate replaces `${ENV}`, `${ENV:-default}` and `${file:/path}`
+// in s by environment variable or content of file, `$$` is `$`.
+func interpolate(s string) (string, error) {
+	var err error
+	out := reference.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$$" {
+			return "$"
+		}
+		name := ref[2 : len(ref)-1]
+		if strings.HasPrefix(name, filePrefix) {
+			data, e := ioutil.ReadFile(strings.TrimPrefix(name, filePrefix))
+			if e != nil && err == nil {
+				err = e
+			}
+			return strings.TrimRight(string(data), "\r\n")
+		}
+		def := ""
+		hasDef := false
+		if i := strings.Index(name, envDefault); i != -1 {
+			name, def, hasDef = name[:i], name[i+len(envDefault):], true
+		}
+		if v, ok := os.LookupEnv(name); ok && (len(v) != 0 || !hasDef) {
+			return v
+		}
+		if !hasDef && err == nil {
+			err = fmt.Errorf("environment variable %s not set", name)
+		}
+		return def
+	})
+	return out, err
+}
+
+// expand interpolates all string values of parsed config.
+func expand(v interface{}, path string) (interface{}, error) {
+	switch t := v.(type) {
+	case string:
+		s, err := interpolate(t)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return s, nil
+	case map[string]interface{}:
+		for k, child := range t {
+			e, err := expand(child, join(path, k))
+			if err != nil {
+				return nil, err
+			}
+			t[k] = e
+		}
+	case []interface{}:
+		for i, child := range t {
+			e, err := expand(child, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			t[i] = e
+		}
+	}
+	return v, nil
+}
+
+func join(path string, key string) string {
+	if len(path) == 0 {
+		return key
+	}
+	return path + "." + key
+}
+
+func envName(key string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+}
+
+// override applies `BROFORCE_<PATH>` variables of environ to root.
+// Path is resolved by existing keys: `BROFORCE_GOCDSHEDULER_HOST` is
+// `gocdSheduler.host`, unknown rest of path is one key in kebab-case
+// (`BROFORCE_HOOKSENSOR_SHUTDOWN_TIMEOUT` is `hookSensor.shutdown-timeout`
+// if hookSensor exists), variables of unknown sections are ignored.
+// Lists are set by comma-separated value.
+func override(root map[string]interface{}, environ []string) {
+	sort.Strings(environ)
+	for _, kv := range environ {
+		i := strings.Index(kv, "=")
+		if i == -1 || !strings.HasPrefix(kv, envPrefix) {
+			continue
+		}
+		set(root, strings.TrimPrefix(kv[:i], envPrefix), kv[i+1:], true)
+	}
+}
+
+func set(node map[string]interface{}, name string, value string, root bool) {
+	keys := make([]string, 0, len(node))
+	for k := range node {
+		keys = append(keys, k)
+	}
+	// longest key first, so `key-data` wins over `key`
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+	for _, k := range keys {
+		n := envName(k)
+		switch {
+		case n == name:
+			if _, ok := node[k].([]interface{}); ok {
+				list := make([]interface{}, 0)
+				for _, v := range strings.Split(value, ",") {
+					list = append(list, strings.TrimSpace(v))
+				}
+				node[k] = list
+			} else {
+				node[k] = value
+			}
+			return
+		case strings.HasPrefix(name, n+"_"):
+			if child, ok := node[k].(map[string]interface{}); ok {
+				set(child, strings.TrimPrefix(name, n+"_"), value, false)
+				return
+			}
+		}
+	}
+	if !root {
+		node[strings.ToLower(strings.Replace(name, "_", "-", -1))] = value
+	}
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterpolate(t *testing.T) {
+	os.Setenv("BROFORCE_TEST_TOKEN", "secret")
+	os.Setenv("BROFORCE_TEST_EMPTY", "")
+	defer os.Unsetenv("BROFORCE_TEST_TOKEN")
+	defer os.Unsetenv("BROFORCE_TEST_EMPTY")
+
+	secret, err := ioutil.TempFile("/tmp", "secret_")
+	assert.NoError(t, err)
+	secret.WriteString("password\n")
+	secret.Close()
+	defer os.Remove(secret.Name())
+
+	for in, out := range map[string]string{
+		"${BROFORCE_TEST_TOKEN}":                "secret",
+		"token ${BROFORCE_TEST_TOKEN}!":         "token secret!",
+		"${BROFORCE_TEST_UNSET:-default}":       "default",
+		"${BROFORCE_TEST_EMPTY:-default}":       "default",
+		"${BROFORCE_TEST_EMPTY}":                "",
+		"${file:" + secret.Name() + "}":         "password",
+		"$${BROFORCE_TEST_TOKEN}":               "${BROFORCE_TEST_TOKEN}",
+		"plain $ text":                          "plain $ text",
+		"http://host:${BROFORCE_TEST_PORT:-80}": "http://host:80",
+	} {
+		s, err := interpolate(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, out, s, in)
+	}
+
+	_, err = interpolate("${BROFORCE_TEST_UNSET}")
+	assert.Error(t, err)
+	_, err = interpolate("${file:/not/exist}")
+	assert.Error(t, err)
+}
+
+func TestOverride(t *testing.T) {
+	root := map[string]interface{}{
+		"gocdSheduler": map[string]interface{}{"host": "https://gocd.ru"},
+		"consulSensor": map[string]interface{}{
+			"consul":      []interface{}{"server1"},
+			"key":         "a",
+			"key-data":    "b",
+			"key-outdate": map[string]interface{}{"path": "c"}}}
+
+	override(root, []string{
+		"BROFORCE_GOCDSHEDULER_HOST=https://gocd.local",
+		"BROFORCE_GOCDSHEDULER_ACCESS_FILE=/run/secrets/gocd",
+		"BROFORCE_CONSULSENSOR_CONSUL=server2, server3",
+		"BROFORCE_CONSULSENSOR_KEY_DATA=data",
+		"BROFORCE_CONSULSENSOR_KEY_OUTDATE_PATH=outdate",
+		"BROFORCE_UNKNOWN_SECTION=value",
+		"BROFORCE_PROFILE=production",
+		"HOME=/root"})
+
+	gocd := root["gocdSheduler"].(map[string]interface{})
+	assert.Equal(t, "https://gocd.local", gocd["host"])
+	assert.Equal(t, "/run/secrets/gocd", gocd["access-file"])
+	consul := root["consulSensor"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"server2", "server3"}, consul["consul"])
+	assert.Equal(t, "a", consul["key"])
+	assert.Equal(t, "data", consul["key-data"])
+	assert.Equal(t, "outdate", consul["key-outdate"].(map[string]interface{})["path"])
+	assert.Equal(t, 2, len(root))
+}
+
+func TestDefaultConfig_Environment(t *testing.T) {
+	os.Setenv("BROFORCE_TEST_TOKEN", "secret")
+	os.Setenv("BROFORCE_JIRARESOLVER_JIRA_USER", "robot")
+	defer os.Unsetenv("BROFORCE_TEST_TOKEN")
+	defer os.Unsetenv("BROFORCE_JIRARESOLVER_JIRA_USER")
+
+	tmpfile, err := ioutil.TempFile("/tmp", "config_")
+	assert.NoError(t, err)
+	tmpfile.WriteString("jiraResolver:\n  jira-user: user\n  jira-password: ${BROFORCE_TEST_TOKEN}\n  unknown-template:\n    - ${BROFORCE_TEST_TOKEN}\n")
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	config := defaultConfig{}
+	assert.NoError(t, config.Init(tmpfile.Name()))
+	assert.Equal(t, "secret", config.Get("jiraResolver").GetString("jira-password"))
+	assert.Equal(t, "robot", config.Get("jiraResolver").GetString("jira-user"))
+	assert.Equal(t, []string{"secret"}, config.Get("jiraResolver").GetArrayString("unknown-template"))
+
+	assert.NoError(t, ioutil.WriteFile(tmpfile.Name(), []byte("jiraResolver:\n  jira-password: ${BROFORCE_TEST_UNSET}\n"), 0644))
+	err = config.Reload(nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "jiraResolver.jira-password")
+	}
+}
@@ -0,0 +1,225 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	StringType   = "string"
+	IntType      = "int"
+	FloatType    = "float"
+	BoolType     = "bool"
+	DurationType = "duration"
+	RegexpType   = "regexp"
+	ListType     = "list"
+	MapType      = "map"
+
+	// AnyKey in Fields describes every key of map without own field.
+	AnyKey = "*"
+)
+
+// Schema describes keys of config section.
+type Schema map[string]Field
+
+// Field describes value of config key. Fields are keys of map, Items
+// is type of list elements. Default documents value used by task when
+// key is missing and is checked as the value itself.
+type Field struct {
+	Type     string
+	Required bool
+	Default  interface{}
+	Values   []string
+	Fields   Schema
+	Items    *Field
+}
+
+// Problem is error of config value at path. Warning is a problem
+// not preventing start, e.g. unknown key.
+type Problem struct {
+	Path    string
+	Message string
+	Warning bool
+}
+
+func (p Problem) Error() string {
+	return fmt.Sprintf("%s: %s", p.Path, p.Message)
+}
+
+// IsWarning reports whether err is Problem with Warning set.
+func IsWarning(err error) bool {
+	p, ok := err.(Problem)
+	return ok && p.Warning
+}
+
+// Validate checks cfg by schema, path is the name of section used in
+// problems. Keys not described by schema are warnings.
+func (p Schema) Validate(cfg ConfigData, path string) []error {
+	var v interface{}
+	if cfg != nil {
+		if err := json.Unmarshal([]byte(cfg.String()), &v); err != nil {
+			return []error{Problem{Path: path, Message: err.Error()}}
+		}
+	}
+	if v == nil {
+		v = map[string]interface{}{}
+	}
+	return Field{Type: MapType, Fields: p}.validate(v, path)
+}
+
+// Merge returns schema with fields of p and other, fields of other
+// win.
+func (p Schema) Merge(other Schema) Schema {
+	out := make(Schema, len(p)+len(other))
+	for k, f := range p {
+		out[k] = f
+	}
+	for k, f := range other {
+		out[k] = f
+	}
+	return out
+}
+
+func (p Field) validate(v interface{}, path string) []error {
+	problem := func(format string, args ...interface{}) []error {
+		return []error{Problem{Path: path, Message: fmt.Sprintf(format, args...)}}
+	}
+	switch p.Type {
+	case StringType:
+		switch v.(type) {
+		case nil, []interface{}, map[string]interface{}:
+			return problem("string expected, got %s", kind(v))
+		}
+	case IntType:
+		if _, err := strconv.Atoi(scalar(v)); err != nil {
+			return problem("int expected, got %s", kind(v))
+		}
+	case FloatType:
+		if _, err := strconv.ParseFloat(scalar(v), 64); err != nil {
+			return problem("float expected, got %s", kind(v))
+		}
+	case BoolType:
+		if _, err := strconv.ParseBool(scalar(v)); err != nil {
+			return problem("bool expected, got %s", kind(v))
+		}
+	case DurationType:
+		s := scalar(v)
+		if _, err := time.ParseDuration(s); err != nil {
+			if _, err := strconv.ParseFloat(s, 64); err != nil {
+				return problem("duration expected (10s, 1m or seconds), got %s", kind(v))
+			}
+		}
+	case RegexpType:
+		s, ok := v.(string)
+		if !ok {
+			return problem("regexp expected, got %s", kind(v))
+		}
+		if _, err := regexp.Compile(s); err != nil {
+			return problem("%v", err)
+		}
+	case ListType:
+		list, ok := v.([]interface{})
+		if !ok {
+			return problem("list expected, got %s", kind(v))
+		}
+		errs := make([]error, 0)
+		if p.Items != nil {
+			for i, item := range list {
+				errs = append(errs, p.Items.validate(item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+		return errs
+	case MapType:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return problem("map expected, got %s", kind(v))
+		}
+		return p.validateMap(m, path)
+	}
+	if len(p.Values) != 0 {
+		s := scalar(v)
+		for _, allowed := range p.Values {
+			if s == allowed {
+				return nil
+			}
+		}
+		return problem("%s not allowed, one of: %s", s, strings.Join(p.Values, ", "))
+	}
+	return nil
+}
+
+func (p Field) validateMap(m map[string]interface{}, path string) []error {
+	if p.Fields == nil {
+		return nil
+	}
+	errs := make([]error, 0)
+	keys := make([]string, 0, len(p.Fields))
+	for k := range p.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		f := p.Fields[k]
+		if k == AnyKey {
+			continue
+		}
+		if v, ok := m[k]; ok {
+			errs = append(errs, f.validate(v, join(path, k))...)
+		} else if f.Required {
+			errs = append(errs, Problem{Path: join(path, k), Message: "required"})
+		} else if f.Default != nil {
+			errs = append(errs, f.validate(f.Default, join(path, k)+" (default)")...)
+		}
+	}
+	keys = keys[:0]
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, ok := p.Fields[k]; ok {
+			continue
+		}
+		if any, ok := p.Fields[AnyKey]; ok {
+			errs = append(errs, any.validate(m[k], join(path, k))...)
+			continue
+		}
+		errs = append(errs, Problem{Path: join(path, k), Message: "unknown key", Warning: true})
+	}
+	return errs
+}
+
+func scalar(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	case nil, []interface{}, map[string]interface{}:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func kind(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return fmt.Sprintf("%q", t)
+	case float64, bool:
+		return fmt.Sprintf("%v", t)
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	}
+	return fmt.Sprintf("%T", v)
+}
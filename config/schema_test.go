@@ -0,0 +1,70 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchema_Validate(t *testing.T) {
+	data := `gocdSheduler:
+  host: https://gocd.ru
+  times: ten
+  interval: "10"
+  retry:
+    delay: 10 parsecs
+  policy: sometimes
+  pipelines:
+    git@github.com/repo.git:
+      pipeline: deploy
+      ref: "^refs/heads/(master"
+    git@github.com/other.git:
+      ref: "^refs/heads/master"
+  unknown-key: 1
+  users: [1, 2, {name: broken}]
+`
+	tmpfile, err := ioutil.TempFile("/tmp", "config_")
+	assert.NoError(t, err)
+	tmpfile.WriteString(data)
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	c, err := Load(tmpfile.Name(), YAMLAdapter)
+	assert.NoError(t, err)
+
+	schema := Schema{
+		"host":     {Type: StringType, Required: true},
+		"access":   {Type: StringType, Required: true},
+		"times":    {Type: IntType, Default: 100},
+		"interval": {Type: IntType},
+		"timeout":  {Type: DurationType, Default: "forever"},
+		"policy":   {Type: StringType, Values: []string{"never", "always"}},
+		"retry": {Type: MapType, Fields: Schema{
+			"delay": {Type: DurationType}}},
+		"users": {Type: ListType, Items: &Field{Type: StringType}},
+		"pipelines": {Type: MapType, Fields: Schema{
+			AnyKey: {Type: MapType, Fields: Schema{
+				"pipeline": {Type: StringType, Required: true},
+				"ref":      {Type: RegexpType, Required: true}}}}}}
+
+	messages := make([]string, 0)
+	for _, err := range schema.Validate(c.Get("gocdSheduler"), "gocdSheduler") {
+		messages = append(messages, err.Error())
+		assert.Equal(t, err.(Problem).Path == "gocdSheduler.unknown-key", IsWarning(err), err.Error())
+	}
+	assert.Equal(t, []string{
+		"gocdSheduler.access: required",
+		"gocdSheduler.pipelines.git@github.com/other.git.pipeline: required",
+		"gocdSheduler.pipelines.git@github.com/repo.git.ref: error parsing regexp: missing closing ): `^refs/heads/(master`",
+		"gocdSheduler.policy: sometimes not allowed, one of: never, always",
+		"gocdSheduler.retry.delay: duration expected (10s, 1m or seconds), got \"10 parsecs\"",
+		"gocdSheduler.timeout (default): duration expected (10s, 1m or seconds), got \"forever\"",
+		"gocdSheduler.times: int expected, got \"ten\"",
+		"gocdSheduler.users[2]: string expected, got map",
+		"gocdSheduler.unknown-key: unknown key"}, messages)
+
+	assert.Equal(t, 3, len(schema.Validate(nil, "empty")))
+	assert.Equal(t, []string{"gocdSheduler"}, c.Sections())
+}
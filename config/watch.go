@@ -0,0 +1,56 @@
+package config
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// Watch calls f when modification time or size of any of files
+// changes or the list of files changes, checking them every interval
+// until ctx is done. Files are asked again on every check, so files
+// added to config are watched too.
+func Watch(ctx context.Context, files func() []string, interval time.Duration, f func()) {
+	last := stat(files())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		current := stat(files())
+		if changed(last, current) {
+			last = current
+			f()
+		}
+	}
+}
+
+func stat(files []string) map[string]os.FileInfo {
+	out := make(map[string]os.FileInfo, len(files))
+	for _, path := range files {
+		info, _ := os.Stat(path)
+		out[path] = info
+	}
+	return out
+}
+
+func changed(last, current map[string]os.FileInfo) bool {
+	if len(last) != len(current) {
+		return true
+	}
+	for path, info := range current {
+		old, ok := last[path]
+		switch {
+		case !ok:
+			return true
+		case info == nil || old == nil:
+			if info != old {
+				return true
+			}
+		case !info.ModTime().Equal(old.ModTime()) || info.Size() != old.Size():
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mhanygin/broforce/admin"
+	"github.com/mhanygin/broforce/bus"
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+	"github.com/mhanygin/broforce/tasks"
+)
+
+// startTimeout is time given to task Run to subscribe handlers before
+// event is published by `emit --local`.
+const startTimeout = time.Second
+
+type emitParams struct {
+	subject string
+	coding  string
+	trace   string
+	data    string
+	headers map[string]string
+	local   bool
+}
+
+func (p *emitParams) read() ([]byte, error) {
+	switch p.data {
+	case "":
+		return make([]byte, 0), nil
+	case "-":
+		return ioutil.ReadAll(os.Stdin)
+	default:
+		return ioutil.ReadFile(p.data)
+	}
+}
+
+// emitCmd publishes event by running instance. With --local tasks are
+// run on in-memory bus, explicit allow is required, so tasks with side
+// effects are not run by mistake.
+func emitCmd(c config.Config, allow string, p *emitParams) error {
+	if _, err := bus.GetCodec(p.coding); err != nil {
+		return err
+	}
+	data, err := p.read()
+	if err != nil {
+		return err
+	}
+	if p.local {
+		if len(allow) == 0 {
+			return fmt.Errorf("emit --local requires --allow")
+		}
+		return emitLocal(c, allow, p, data)
+	}
+
+	v := url.Values{}
+	v.Set("subject", p.subject)
+	v.Set("coding", p.coding)
+	if len(p.trace) != 0 {
+		v.Set("trace", p.trace)
+	}
+	for k, h := range p.headers {
+		v.Add("header", fmt.Sprintf("%s=%s", k, h))
+	}
+	if _, ok := p.headers[bus.SourceHeader]; !ok {
+		v.Add("header", fmt.Sprintf("%s=emit", bus.SourceHeader))
+	}
+	resp, err := http.Post(fmt.Sprintf("%s/publish?%s", admin.URL(c.Get("admin")), v.Encode()), "application/octet-stream", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	result := struct {
+		ID    string `json:"id"`
+		Trace string `json:"trace"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	fmt.Printf("%s published, trace: %s\n", result.ID, result.Trace)
+	return nil
+}
+
+// emitLocal runs allowed tasks on in-memory bus, publishes event and
+// waits until handlers of it and of events derived from it are done.
+// Adapters and stores of bus section are not used, so the event is not
+// delivered to running instance nor written to WAL, dead-letter or event
+// store.
+func emitLocal(c config.Config, allow string, p *emitParams, data []byte) error {
+	if err := check(c, allow); err != nil {
+		return err
+	}
+	instances, err := tasks.GetInstances(c, allow)
+	if err != nil {
+		return err
+	}
+
+	stop, cancel := context.WithCancel(context.Background())
+	b := bus.NewLocal()
+	stopped := make([]func() error, 0)
+	for _, i := range instances {
+		ctx := bus.Context{
+			Ctx:    stop,
+			Name:   i.Name,
+			Type:   i.Type,
+			Config: i.Config,
+			Log:    logger.Logger4Handler(i.Name, ""),
+			Bus:    b}
+		if l, ok := i.Task.(bus.Lifecycle); ok {
+			if err := l.Init(ctx); err != nil {
+				logger.Log.Errorf("%s: %v", i.Name, err)
+				continue
+			}
+			stopped = append(stopped, func() error { return l.Stop(ctx) })
+		}
+		done := make(chan struct{})
+		go func(t bus.Task) {
+			defer close(done)
+			if err := t.Run(ctx); err != nil {
+				logger.Log.Errorf("%s: %v", ctx.Name, err)
+			}
+		}(i.Task)
+		select {
+		case <-done:
+		case <-time.After(startTimeout):
+		}
+	}
+
+	trace := p.trace
+	if len(trace) == 0 {
+		trace = bus.NewUUID()
+	}
+	e := bus.NewEvent(trace, p.subject, p.coding)
+	e.Data = data
+	for k, v := range p.headers {
+		e.SetHeader(k, v)
+	}
+	if len(e.Source()) == 0 {
+		e.SetHeader(bus.SourceHeader, "emit")
+	}
+	perr := b.Publish(*e)
+	if perr == nil {
+		fmt.Printf("%s published, trace: %s\n", e.ID(), e.Trace)
+	}
+
+	timeout := time.Duration(c.Get("bus").GetIntOr("drain-timeout", defaultDrainTimeout)) * time.Second
+	drain, done := context.WithTimeout(context.Background(), timeout)
+	defer done()
+
+	cancel()
+	for _, s := range stopped {
+		if err := s(); err != nil {
+			logger.Log.Errorf("stop: %v", err)
+		}
+	}
+	if err := b.Shutdown(drain); err != nil {
+		return err
+	}
+	return perr
+}
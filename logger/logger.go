@@ -20,6 +20,7 @@ func New(cfg config.ConfigData) *logrus.Logger {
 	once.Do(func() {
 		Log = logrus.StandardLogger()
 		Log.Formatter = &logrus.TextFormatter{TimestampFormat: time.RFC3339, FullTimestamp: true}
+		logrus.AddHook(recent)
 
 		if cfg.Exist("file") {
 			f, err := os.OpenFile(cfg.GetStringOr("file.name", "broforce.log"), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
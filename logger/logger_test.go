@@ -5,6 +5,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/mhanygin/broforce/config"
@@ -32,3 +33,29 @@ func TestNew(t *testing.T) {
 	}
 	assert.NotNil(t, New(cfg.Get("logger")))
 }
+
+func TestRecentErrors(t *testing.T) {
+	hook := newRecentHook(2)
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.Hooks.Add(hook)
+
+	log.WithFields(logrus.Fields{"handler": "test", "trace": "1"}).Error("first")
+	log.Info("skipped")
+	log.Error("second")
+	log.Error("third")
+
+	entries := hook.list()
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "second", entries[0].Message)
+	assert.Equal(t, "third", entries[1].Message)
+
+	hook = newRecentHook(2)
+	log.Hooks = make(logrus.LevelHooks)
+	log.Hooks.Add(hook)
+	log.WithFields(logrus.Fields{"handler": "test", "trace": "1"}).Error("first")
+	entries = hook.list()
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "test", entries[0].Handler)
+	assert.Equal(t, "1", entries[0].Trace)
+}
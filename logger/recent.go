@@ -0,0 +1,72 @@
+package logger
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const defaultRecentSize = 100
+
+type RecentEntry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Handler string    `json:"handler,omitempty"`
+	Trace   string    `json:"trace,omitempty"`
+	Message string    `json:"message"`
+}
+
+// recentHook keeps last error entries in ring buffer.
+type recentHook struct {
+	lock    sync.Mutex
+	entries []RecentEntry
+	next    int
+	full    bool
+}
+
+var recent = newRecentHook(defaultRecentSize)
+
+func newRecentHook(size int) *recentHook {
+	return &recentHook{entries: make([]RecentEntry, size)}
+}
+
+func (p *recentHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel}
+}
+
+func (p *recentHook) Fire(entry *logrus.Entry) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	re := RecentEntry{Time: entry.Time, Level: entry.Level.String(), Message: entry.Message}
+	if h, ok := entry.Data["handler"]; ok {
+		re.Handler = fmt.Sprintf("%v", h)
+	}
+	if t, ok := entry.Data["trace"]; ok {
+		re.Trace = fmt.Sprintf("%v", t)
+	}
+	p.entries[p.next] = re
+	p.next = (p.next + 1) % len(p.entries)
+	if p.next == 0 {
+		p.full = true
+	}
+	return nil
+}
+
+func (p *recentHook) list() []RecentEntry {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	result := make([]RecentEntry, 0, len(p.entries))
+	if p.full {
+		result = append(result, p.entries[p.next:]...)
+	}
+	return append(result, p.entries[:p.next]...)
+}
+
+// RecentErrors returns last logged errors, the oldest first.
+func RecentErrors() []RecentEntry {
+	return recent.list()
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "broforce"
+
+var (
+	published = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_published_total",
+		Help:      "Events published to the bus."},
+		[]string{"subject"})
+	delivered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_delivered_total",
+		Help:      "Events handled successfully."},
+		[]string{"subject", "handler"})
+	failed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_failed_total",
+		Help:      "Failed attempts of event handling."},
+		[]string{"subject", "handler"})
+	handlerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "handler_duration_seconds",
+		Help:      "Duration of event handling attempt.",
+		Buckets:   []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300}},
+		[]string{"handler"})
+	webhooks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_requests_total",
+		Help:      "Webhook requests by endpoint and status code."},
+		[]string{"endpoint", "code"})
+	externalDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "external_call_duration_seconds",
+		Help:      "Duration of calls to external services.",
+		Buckets:   prometheus.DefBuckets},
+		[]string{"service", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(published, delivered, failed, handlerDuration, webhooks, externalDuration)
+}
+
+func Published(subject string) {
+	published.WithLabelValues(subject).Inc()
+}
+
+func Handled(subject string, handler string, d time.Duration, err error) {
+	handlerDuration.WithLabelValues(handler).Observe(d.Seconds())
+	if err != nil {
+		failed.WithLabelValues(subject, handler).Inc()
+	} else {
+		delivered.WithLabelValues(subject, handler).Inc()
+	}
+}
+
+func External(service string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	externalDuration.WithLabelValues(service, result).Observe(time.Since(start).Seconds())
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (p *statusWriter) WriteHeader(code int) {
+	p.code = code
+	p.ResponseWriter.WriteHeader(code)
+}
+
+// Webhook counts requests to endpoint by response code.
+func Webhook(endpoint string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		h(sw, r)
+		webhooks.WithLabelValues(endpoint, strconv.Itoa(sw.code)).Inc()
+	}
+}
+
+// Handler exposes metrics in Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// delta returns increase of collector c made by f, collectors are
+// global, so absolute values depend on other tests and runs.
+func delta(c prometheus.Collector, f func()) float64 {
+	before := testutil.ToFloat64(c)
+	f()
+	return testutil.ToFloat64(c) - before
+}
+
+func TestHandled(t *testing.T) {
+	ok := delivered.WithLabelValues("serve.manifest", "ServeHandler")
+	ko := failed.WithLabelValues("serve.manifest", "ServeHandler")
+
+	assert.Equal(t, float64(1), delta(ok, func() {
+		assert.Equal(t, float64(2), delta(ko, func() {
+			Handled("serve.manifest", "ServeHandler", time.Second, nil)
+			Handled("serve.manifest", "ServeHandler", time.Second, fmt.Errorf("failed"))
+			Handled("serve.manifest", "ServeHandler", time.Second, fmt.Errorf("failed"))
+		}))
+	}))
+}
+
+func TestWebhook(t *testing.T) {
+	h := Webhook("/git", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("api-key") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	})
+
+	assert.Equal(t, float64(1), delta(webhooks.WithLabelValues("/git", "200"), func() {
+		h(httptest.NewRecorder(), httptest.NewRequest("POST", "/git?api-key=secret", nil))
+	}))
+	assert.Equal(t, float64(1), delta(webhooks.WithLabelValues("/git", "403"), func() {
+		h(httptest.NewRecorder(), httptest.NewRequest("POST", "/git", nil))
+	}))
+}
+
+func TestHandler(t *testing.T) {
+	assert.Equal(t, float64(1), delta(published.WithLabelValues("timer.tick"), func() {
+		Published("timer.tick")
+	}))
+	External("gitlab", time.Now(), nil)
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := w.Body.String()
+	assert.True(t, strings.Contains(body, `broforce_events_published_total{subject="timer.tick"}`))
+	assert.True(t, strings.Contains(body, `broforce_external_call_duration_seconds_count{result="ok",service="gitlab"}`))
+}
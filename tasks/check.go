@@ -0,0 +1,73 @@
+package tasks
+
+import (
+	"github.com/mhanygin/broforce/bus"
+	"github.com/mhanygin/broforce/config"
+)
+
+// Configurable is implemented by tasks declaring schema of their
+// config section. Keys read by the bus (retry, restart, pool, breaker)
+// are added to it.
+type Configurable interface {
+	Schema() config.Schema
+}
+
+var retryFields = config.Schema{
+	"retry":      {Type: config.IntType},
+	"delay":      {Type: config.DurationType},
+	"max-delay":  {Type: config.DurationType},
+	"multiplier": {Type: config.FloatType},
+	"jitter":     {Type: config.FloatType}}
+
+var commonSchema = config.Schema{
+	"retry": {Type: config.MapType, Fields: retryFields},
+	"restart": {Type: config.MapType, Fields: retryFields.Merge(config.Schema{
+		"policy": {Type: config.StringType, Default: bus.OnFailureRestart,
+			Values: []string{bus.NeverRestart, bus.OnFailureRestart, bus.AlwaysRestart}}})},
+	"pool": {Type: config.MapType, Fields: config.Schema{
+		"workers": {Type: config.IntType},
+		"queue":   {Type: config.IntType},
+		"policy": {Type: config.StringType, Default: bus.BlockPolicy,
+			Values: []string{bus.BlockPolicy, bus.DropOldestPolicy, bus.RejectPolicy}}}},
+	"breaker": {Type: config.MapType, Fields: config.Schema{
+		"threshold": {Type: config.IntType},
+		"timeout":   {Type: config.DurationType}}}}
+
+var instanceSchema = config.Schema{
+	"name": {Type: config.StringType, Required: true},
+	"type": {Type: config.StringType, Required: true}}
+
+// Check validates config of allowed tasks by their schemas and reports
+// top-level sections that are neither tasks nor one of sections.
+// Tasks without section of their own are not checked, they run with
+// empty config.
+func Check(c config.Config, allow string, sections ...string) []error {
+	instances, err := GetInstances(c, allow)
+	if err != nil {
+		return []error{err}
+	}
+
+	errs := make([]error, 0)
+	known := map[string]bool{"instances": true}
+	for _, s := range sections {
+		known[s] = true
+	}
+	for _, s := range c.Sections() {
+		if _, ok := tasksPool[s]; !ok && !known[s] {
+			errs = append(errs, config.Problem{Path: s, Message: "unknown section", Warning: true})
+		}
+	}
+
+	for _, i := range instances {
+		t, ok := i.Task.(Configurable)
+		if !ok || (i.Path == i.Type && !c.Exist(i.Type)) {
+			continue
+		}
+		schema := commonSchema.Merge(t.Schema())
+		if i.Path != i.Type {
+			schema = schema.Merge(instanceSchema)
+		}
+		errs = append(errs, schema.Validate(i.Config, i.Path)...)
+	}
+	return errs
+}
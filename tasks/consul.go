@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Jeffail/gabs"
@@ -11,11 +12,12 @@ import (
 
 	"github.com/mhanygin/broforce/bus"
 	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/metrics"
 )
 
 func init() {
-	registry("consulSensor", bus.Task(&consulSensor{}))
-	registry("outdated", bus.Task(&outdatedConsul{}))
+	registry("consulSensor", func() bus.Task { return &consulSensor{} })
+	registry("outdated", func() bus.Task { return &outdatedConsul{} })
 }
 
 //config section
@@ -34,14 +36,33 @@ const (
 	loopInterval   = 10
 )
 
+// outdatedEvent carries key prefixes of consulSensor, so the handler
+// works with the same keys; empty prefixes are the default ones.
 type outdatedEvent struct {
-	EndOfLife int64  `json:"endOfLife"`
-	Key       string `json:"key"`
-	Address   string `json:"address"`
+	EndOfLife      int64  `json:"endOfLife"`
+	Key            string `json:"key"`
+	Address        string `json:"address"`
+	OutdatedPrefix string `json:"keyOutdate,omitempty"`
+	DataPrefix     string `json:"keyData,omitempty"`
+}
+
+func (p *outdatedEvent) prefixes() (outdated string, data string) {
+	outdated, data = outdatedPrefix, dataPrefix
+	if len(p.OutdatedPrefix) != 0 {
+		outdated = p.OutdatedPrefix
+	}
+	if len(p.DataPrefix) != 0 {
+		data = p.DataPrefix
+	}
+	return strings.TrimSuffix(outdated, "/"), strings.TrimSuffix(data, "/")
 }
 
 type consulSensor struct {
-	clientsPool map[string]*api.Client
+	clientsPool    map[string]*api.Client
+	outdatedPrefix string
+	dataPrefix     string
+	lock           sync.Mutex
+	health         error
 }
 
 func (p *consulSensor) prepareConfig(cfg config.ConfigData) []*api.Config {
@@ -54,8 +75,17 @@ func (p *consulSensor) prepareConfig(cfg config.ConfigData) []*api.Config {
 	return dc
 }
 
-func (p *consulSensor) Run(ctx bus.Context) error {
+func (p *consulSensor) Schema() config.Schema {
+	return config.Schema{
+		"consul":      {Type: config.ListType, Required: true, Items: &config.Field{Type: config.StringType}},
+		"key-outdate": {Type: config.StringType, Default: outdatedPrefix},
+		"key-data":    {Type: config.StringType, Default: dataPrefix}}
+}
+
+func (p *consulSensor) Init(ctx bus.Context) error {
 	p.clientsPool = make(map[string]*api.Client)
+	p.outdatedPrefix = strings.TrimSuffix(ctx.Config.GetStringOr("key-outdate", outdatedPrefix), "/")
+	p.dataPrefix = strings.TrimSuffix(ctx.Config.GetStringOr("key-data", dataPrefix), "/")
 
 	for _, c := range p.prepareConfig(ctx.Config) {
 		client, err := api.NewClient(c)
@@ -65,12 +95,23 @@ func (p *consulSensor) Run(ctx bus.Context) error {
 		}
 		p.clientsPool[c.Address] = client
 	}
+	if len(p.clientsPool) == 0 {
+		return fmt.Errorf("no consul clients")
+	}
+	return nil
+}
+
+func (p *consulSensor) Run(ctx bus.Context) error {
 	for {
+		errs := make(bus.Errors, 0)
 		for address, client := range p.clientsPool {
 			kv := client.KV()
-			pairs, _, err := kv.List(outdatedPrefix, nil)
+			start := time.Now()
+			pairs, _, err := kv.List(p.outdatedPrefix, nil)
+			metrics.External("consul", start, err)
 			if err != nil {
 				ctx.Log.Error(err)
+				errs = append(errs, fmt.Errorf("%s: %v", address, err))
 				continue
 			}
 			for _, key := range pairs {
@@ -87,11 +128,12 @@ func (p *consulSensor) Run(ctx bus.Context) error {
 						string(key.Key),
 						string(key.Value))
 
-					outdated.Key = strings.Replace(key.Key, fmt.Sprintf("%s/", outdatedPrefix), "", 1)
+					outdated.Key = strings.Replace(key.Key, fmt.Sprintf("%s/", p.outdatedPrefix), "", 1)
 					outdated.Address = address
+					outdated.OutdatedPrefix, outdated.DataPrefix = p.outdatedPrefix, p.dataPrefix
 					if event, err := bus.NewEventWithData(bus.NewUUID(), bus.OutdatedEvent, bus.JsonCoding, outdated); err != nil {
 						ctx.Log.Error(err)
-					} else if err := ctx.Bus.Publish(*event); err != nil {
+					} else if err := ctx.Publish(*event); err != nil {
 						ctx.Log.Error(err)
 					}
 				} else {
@@ -103,12 +145,35 @@ func (p *consulSensor) Run(ctx bus.Context) error {
 				}
 			}
 		}
-		time.Sleep(loopInterval * time.Second)
+		p.setHealth(errs)
+		select {
+		case <-ctx.Ctx.Done():
+			ctx.Log.Debug("consulSensor Complete")
+			return nil
+		case <-time.After(loopInterval * time.Second):
+		}
 	}
-	ctx.Log.Debug("consulSensor Complete")
+}
+
+func (p *consulSensor) Stop(ctx bus.Context) error {
 	return nil
 }
 
+func (p *consulSensor) setHealth(errs bus.Errors) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.health = nil
+	if len(errs) != 0 {
+		p.health = errs
+	}
+}
+
+func (p *consulSensor) Health() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.health
+}
+
 type outdatedConsul struct {
 }
 
@@ -127,7 +192,10 @@ func (p *outdatedConsul) handler(e bus.Event, ctx bus.Context) error {
 		return err
 	}
 	kv := client.KV()
-	pairs, _, err := kv.List(fmt.Sprintf("%s/%s/", dataPrefix, event.Key), nil)
+	outdated, data := event.prefixes()
+	start := time.Now()
+	pairs, _, err := kv.List(fmt.Sprintf("%s/%s/", data, event.Key), nil)
+	metrics.External("consul", start, err)
 	if err != nil {
 		return err
 	}
@@ -135,17 +203,18 @@ func (p *outdatedConsul) handler(e bus.Event, ctx bus.Context) error {
 	if len(pairs) == 0 {
 		ctx.Log.Infof("%s: key %s empty, delete key: %s",
 			conf.Address,
-			fmt.Sprintf("%s/%s/", dataPrefix, event.Key),
-			fmt.Sprintf("%s/%s", outdatedPrefix, event.Key))
+			fmt.Sprintf("%s/%s/", data, event.Key),
+			fmt.Sprintf("%s/%s", outdated, event.Key))
 
-		if _, err := kv.Delete(fmt.Sprintf("%s/%s", outdatedPrefix, event.Key), nil); err != nil {
+		start := time.Now()
+		_, err := kv.Delete(fmt.Sprintf("%s/%s", outdated, event.Key), nil)
+		metrics.External("consul", start, err)
+		if err != nil {
 			return err
 		}
 		return nil
 	}
 
-	serveEvent := bus.NewEvent(e.Trace, bus.ServeCmdWithDataEvent, bus.JsonCoding)
-
 	for _, key := range pairs {
 		ctx.Log.Debugf("%s purge: %v=%v", conf.Address, string(key.Key), string(key.Value))
 		g, err := gabs.ParseJSON(key.Value)
@@ -161,21 +230,29 @@ func (p *outdatedConsul) handler(e bus.Event, ctx bus.Context) error {
 			Plugin:   plugin[len(plugin)-1],
 			Manifest: g.Bytes()}
 
-		if err := serveEvent.Marshal(params); err != nil {
+		serveEvent, err := e.DeriveWithData(bus.ServeCmdWithDataEvent, bus.JsonCoding, params)
+		if err != nil {
 			ctx.Log.Error(err)
 			continue
 		}
-		if err := ctx.Bus.Publish(*serveEvent); err != nil {
+		// orders purges of one service only, manifest orders deploys
+		// by <repo>/<branch> which is not known here
+		serveEvent.SetHeader(bus.OrderKeyHeader, event.Key)
+		if err := ctx.Publish(*serveEvent); err != nil {
 			ctx.Log.Error(err)
 		}
 	}
 	return nil
 }
 
+func (p *outdatedConsul) Schema() config.Schema {
+	return config.Schema{}
+}
+
 func (p *outdatedConsul) Run(ctx bus.Context) error {
 	ctx.Bus.Subscribe(bus.OutdatedEvent, bus.Context{
 		Func:   p.handler,
-		Name:   "OutdatedHandler",
+		Name:   ctx.Named("OutdatedHandler"),
 		Bus:    ctx.Bus,
 		Config: ctx.Config})
 	return nil
@@ -0,0 +1,62 @@
+package tasks
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mhanygin/broforce/bus"
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+)
+
+func TestOutdatedPrefixes(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("/tmp", "config_")
+	assert.NoError(t, err)
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+	c, err := config.Load(tmpfile.Name(), config.YAMLAdapter)
+	assert.NoError(t, err)
+	logger.New(c.Get("logger"))
+
+	lock := sync.Mutex{}
+	requests := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		lock.Unlock()
+		if r.Method == http.MethodGet {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("true"))
+	}))
+	defer server.Close()
+
+	e, err := bus.NewEventWithData("trace", bus.OutdatedEvent, bus.JsonCoding, outdatedEvent{
+		Key:            "app",
+		Address:        strings.TrimPrefix(server.URL, "http://"),
+		OutdatedPrefix: "custom/outdated",
+		DataPrefix:     "custom/data/"})
+	assert.NoError(t, err)
+
+	p := &outdatedConsul{}
+	err = p.handler(*e, bus.Context{Name: "outdated", Log: logger.Logger4Handler("outdated", "")})
+	assert.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []string{
+		"GET /v1/kv/custom/data/app/",
+		"DELETE /v1/kv/custom/outdated/app"}, requests)
+
+	outdated, data := (&outdatedEvent{}).prefixes()
+	assert.Equal(t, outdatedPrefix, outdated)
+	assert.Equal(t, dataPrefix, data)
+}
@@ -6,16 +6,18 @@ import (
 	"io/ioutil"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Jeffail/gabs"
 	"github.com/mhanygin/go-gocd"
 
 	"github.com/mhanygin/broforce/bus"
+	"github.com/mhanygin/broforce/config"
 )
 
 func init() {
-	registry("gocdSheduler", bus.Task(&gocdSheduler{}))
+	registry("gocdSheduler", func() bus.Task { return &gocdSheduler{} })
 }
 
 //config section
@@ -25,6 +27,9 @@ func init() {
 //  access: path/to/login/and/password
 //  times: 360
 //  interval: 10
+//  schedule-retry:
+//    multiplier: 2
+//    max-delay: 5m
 //
 //  pipelines:
 //    git@github.com/repo_name.git:
@@ -38,14 +43,27 @@ const (
 )
 
 type gocdSheduler struct {
+	lock     sync.Mutex
+	settings *gocdSettings
+	breaker  *bus.Breaker
+}
+
+type gocdSettings struct {
+	config   config.ConfigData
 	login    string
 	password string
 	host     string
-	times    int
-	interval time.Duration
+	retry    bus.SafeParams
+}
+
+func (p *gocdSheduler) current() *gocdSettings {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.settings
 }
 
 func (p *gocdSheduler) handler(e bus.Event, ctx bus.Context) error {
+	s := p.current()
 	if e.Coding != bus.JsonCoding {
 		return nil
 	}
@@ -61,16 +79,16 @@ func (p *gocdSheduler) handler(e bus.Event, ctx bus.Context) error {
 	if !ok {
 		return fmt.Errorf("Key %s not found", "ref")
 	}
-	for gitName := range ctx.Config.GetMap("pipelines") {
+	for gitName := range s.config.GetMap("pipelines") {
 		if strings.Compare(gitName, git) == 0 {
 
 			ctx.Log.Debugf("%s: %s = %s",
 				ref,
 				fmt.Sprintf("pipelines.%s.ref", gitName),
-				ctx.Config.Search("pipelines", gitName, "ref"))
+				s.config.Search("pipelines", gitName, "ref"))
 
-			if match, _ := regexp.MatchString(ctx.Config.Search("pipelines", gitName, "ref"), ref); !match {
-				ctx.Log.Debugf("%s not math %s", ctx.Config.Search("pipelines", gitName, "ref"), ref)
+			if match, _ := regexp.MatchString(s.config.Search("pipelines", gitName, "ref"), ref); !match {
+				ctx.Log.Debugf("%s not math %s", s.config.Search("pipelines", gitName, "ref"), ref)
 				return nil
 			}
 			if before, ok := g.Path("before").Data().(string); ok && strings.Compare(before, defaultSHA) == 0 {
@@ -81,30 +99,55 @@ func (p *gocdSheduler) handler(e bus.Event, ctx bus.Context) error {
 			if !ok {
 				return fmt.Errorf("Key %s not found", "ref")
 			}
-			s := strings.Split(ref, "/")
-			Branch := s[len(s)-1]
+			r := strings.Split(ref, "/")
+			Branch := r[len(r)-1]
 			vars := fmt.Sprintf("variables[BRANCH]=%s&variables[SHA]=%s", Branch, Sha)
 
-			client := gocd.New(p.host, p.login, p.password)
-			for i := 0; i < p.times; i++ {
-				if err := client.SchedulePipeline(ctx.Config.Search("pipelines", gitName, "pipeline"), []byte(vars)); err != nil {
+			client := gocd.New(s.host, s.login, s.password)
+			if err := s.retry.DoContext(ctx.Ctx, func(attempt int) error {
+				err := p.breaker.Do(func() error {
+					return client.SchedulePipeline(s.config.Search("pipelines", gitName, "pipeline"), []byte(vars))
+				})
+				if err != nil {
 					ctx.Log.Error(err)
-					time.Sleep(p.interval * time.Second)
-				} else {
-					break
 				}
+				return err
+			}); err != nil {
+				return err
 			}
 		}
 	}
 	return nil
 }
 
-func (p *gocdSheduler) Run(ctx bus.Context) error {
-	p.host = ctx.Config.GetString("host")
-	p.times = ctx.Config.GetIntOr("times", defaultTimes)
-	p.interval = time.Duration(ctx.Config.GetIntOr("interval", defaultInterval))
+func (p *gocdSheduler) Schema() config.Schema {
+	return config.Schema{
+		"host":           {Type: config.StringType, Required: true},
+		"access":         {Type: config.StringType, Required: true},
+		"times":          {Type: config.IntType, Default: defaultTimes},
+		"interval":       {Type: config.IntType, Default: defaultInterval},
+		"schedule-retry": {Type: config.MapType, Fields: retryFields},
+		"pipelines": {Type: config.MapType, Fields: config.Schema{
+			config.AnyKey: {Type: config.MapType, Fields: config.Schema{
+				"pipeline": {Type: config.StringType, Required: true},
+				"ref":      {Type: config.RegexpType, Required: true}}}}}}
+}
+
+func (p *gocdSheduler) configure(cfg config.ConfigData) error {
+	// scheduling is retried by its own section: `retry` is applied by
+	// the bus to the handler and would multiply attempts
+	times := cfg.GetIntOr("times", defaultTimes)
+	if times < 1 {
+		times = 1
+	}
+	s := &gocdSettings{
+		config: cfg,
+		host:   cfg.GetString("host"),
+		retry: bus.NewSafeParams(cfg.Get("schedule-retry"), bus.SafeParams{
+			Retry: times - 1,
+			Delay: time.Duration(cfg.GetIntOr("interval", defaultInterval)) * time.Second})}
 
-	if data, err := ioutil.ReadFile(ctx.Config.GetString("access")); err == nil {
+	if data, err := ioutil.ReadFile(cfg.GetString("access")); err == nil {
 		cread := struct {
 			Login    string `json:"login"`
 			Password string `json:"password"`
@@ -112,14 +155,32 @@ func (p *gocdSheduler) Run(ctx bus.Context) error {
 		if err := json.Unmarshal(data, &cread); err != nil {
 			return err
 		}
-		p.login = cread.Login
-		p.password = cread.Password
+		s.login = cread.Login
+		s.password = cread.Password
 	} else {
 		return err
 	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.settings = s
+	return nil
+}
+
+// Reload applies new pipelines, host, access and retry, breaker state
+// is kept.
+func (p *gocdSheduler) Reload(ctx bus.Context) error {
+	return p.configure(ctx.Config)
+}
+
+func (p *gocdSheduler) Run(ctx bus.Context) error {
+	p.breaker = bus.NewBreaker("gocd", ctx.Config.Get("breaker"), ctx)
+	if err := p.configure(ctx.Config); err != nil {
+		return err
+	}
 	ctx.Bus.Subscribe(bus.GitlabHookEvent, bus.Context{
 		Func:   p.handler,
-		Name:   "GoCDShedulerHandler",
+		Name:   ctx.Named("GoCDShedulerHandler"),
 		Bus:    ctx.Bus,
 		Config: ctx.Config})
 	return nil
@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"net/http"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/Jeffail/gabs"
 	"github.com/andygrunwald/go-jira"
@@ -13,11 +15,12 @@ import (
 	"github.com/valyala/fasttemplate"
 
 	"github.com/mhanygin/broforce/bus"
+	"github.com/mhanygin/broforce/config"
 )
 
 func init() {
-	registry("jiraResolver", bus.Task(&jiraResolver{}))
-	registry("jiraCommenter", bus.Task(&jiraCommenter{}))
+	registry("jiraResolver", func() bus.Task { return &jiraResolver{} })
+	registry("jiraCommenter", func() bus.Task { return &jiraCommenter{} })
 }
 
 //config section
@@ -42,6 +45,12 @@ func createLink(issue *jira.Issue) string {
 }
 
 type jiraResolver struct {
+	lock     sync.Mutex
+	settings *jiraSettings
+	breaker  *bus.Breaker
+}
+
+type jiraSettings struct {
 	host     string
 	user     string
 	password string
@@ -50,25 +59,62 @@ type jiraResolver struct {
 	unknown  []*fasttemplate.Template
 }
 
-func (p *jiraResolver) Run(ctx bus.Context) error {
+func (p *jiraResolver) current() *jiraSettings {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.settings
+}
+
+func (p *jiraResolver) Schema() config.Schema {
+	return config.Schema{
+		"jira-host":        {Type: config.StringType, Required: true},
+		"jira-user":        {Type: config.StringType, Required: true},
+		"jira-password":    {Type: config.StringType, Required: true},
+		"input-template":   {Type: config.RegexpType, Required: true},
+		"output-template":  {Type: config.StringType, Required: true},
+		"unknown-template": {Type: config.ListType, Items: &config.Field{Type: config.StringType}}}
+}
+
+// Reload applies new templates and credentials, invalid input-template
+// keeps the old settings.
+func (p *jiraResolver) Reload(ctx bus.Context) error {
+	cfg := struct {
+		Host     string   `json:"jira-host"`
+		User     string   `json:"jira-user"`
+		Password string   `json:"jira-password"`
+		Input    string   `json:"input-template"`
+		Output   string   `json:"output-template"`
+		Unknown  []string `json:"unknown-template"`
+	}{}
+	if err := ctx.Config.Decode("", &cfg); err != nil {
+		return err
+	}
+
 	var err error
-	if p.reg, err = regexp.Compile(ctx.Config.GetStringOr("input-template", "")); err != nil {
+	s := &jiraSettings{host: cfg.Host, user: cfg.User, password: cfg.Password}
+	if s.reg, err = regexp.Compile(cfg.Input); err != nil {
 		return err
 	}
+	s.output = fasttemplate.New(cfg.Output, "{{", "}}")
+	for _, t := range cfg.Unknown {
+		s.unknown = append(s.unknown, fasttemplate.New(t, "{{", "}}"))
+	}
 
-	p.output = fasttemplate.New(ctx.Config.GetStringOr("output-template", ""), "{{", "}}")
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.settings = s
+	return nil
+}
 
-	for _, t := range ctx.Config.GetArray("unknown-template") {
-		p.unknown = append(p.unknown, fasttemplate.New(t.String(), "{{", "}}"))
+func (p *jiraResolver) Run(ctx bus.Context) error {
+	if err := p.Reload(ctx); err != nil {
+		return err
 	}
-
-	p.host = ctx.Config.GetStringOr("jira-host", "")
-	p.user = ctx.Config.GetStringOr("jira-user", "")
-	p.password = ctx.Config.GetStringOr("jira-password", "")
+	p.breaker = bus.NewBreaker("jira", ctx.Config.Get("breaker"), ctx)
 
 	ctx.Bus.Subscribe(bus.SlackMsgEvent, bus.Context{
 		Func:   p.handler,
-		Name:   "JiraResolverHandler",
+		Name:   ctx.Named("JiraResolverHandler"),
 		Bus:    ctx.Bus,
 		Config: ctx.Config})
 
@@ -76,23 +122,28 @@ func (p *jiraResolver) Run(ctx bus.Context) error {
 }
 
 func (p *jiraResolver) handler(e bus.Event, ctx bus.Context) error {
+	conf := p.current()
 	msg := slackMessage{}
 	if err := e.Unmarshal(&msg); err != nil {
 		return err
 	}
 
-	jiraClient, err := jira.NewClient(nil, p.host)
+	jiraClient, err := jira.NewClient(nil, conf.host)
 	if err != nil {
 		return err
 	}
-	if res, err := jiraClient.Authentication.AcquireSessionCookie(p.user, p.password); err != nil || res == false {
+	if err := p.breaker.Do(func() error {
+		res, err := jiraClient.Authentication.AcquireSessionCookie(conf.user, conf.password)
+		if err == nil && !res {
+			return bus.Permanent(fmt.Errorf("jira: authentication failed for %s", conf.user))
+		}
+		return err
+	}); err != nil {
 		return err
 	}
 
-	event := bus.NewEvent(e.Trace, bus.SlackPostEvent, bus.JsonCoding)
-
 	set := make(map[string]bool)
-	for _, s := range p.reg.FindAllString(msg.Text, -1) {
+	for _, s := range conf.reg.FindAllString(msg.Text, -1) {
 		if _, found := set[s]; found {
 			continue
 		} else {
@@ -100,54 +151,86 @@ func (p *jiraResolver) handler(e bus.Event, ctx bus.Context) error {
 		}
 
 		ctx.Log.Debug("Get issue:", s)
-		issue, _, err := jiraClient.Issue.Get(s, nil)
+		issue, err := p.issue(jiraClient, s)
 		if err != nil {
 
 			ctx.Log.Error(err)
 
-			if len(p.unknown) > 0 {
-				if err := event.Marshal(slackMessage{
+			if len(conf.unknown) > 0 {
+				event, err := e.DeriveWithData(bus.SlackPostEvent, bus.JsonCoding, slackMessage{
 					Type:    msg.Type,
 					Channel: msg.Channel,
-					Text: p.unknown[rand.Intn(len(p.unknown)-1)].ExecuteString(map[string]interface{}{
-						"key": s})}); err != nil {
+					Text: conf.unknown[rand.Intn(len(conf.unknown)-1)].ExecuteString(map[string]interface{}{
+						"key": s})})
+				if err != nil {
 					return err
 				}
-				if err := ctx.Bus.Publish(*event); err != nil {
+				if err := ctx.Publish(*event); err != nil {
 					return err
 				}
 			}
 			continue
 		}
 
-		if err := event.Marshal(slackMessage{
+		event, err := e.DeriveWithData(bus.SlackPostEvent, bus.JsonCoding, slackMessage{
 			Type:    msg.Type,
 			Channel: msg.Channel,
-			Text: p.output.ExecuteString(map[string]interface{}{
+			Text: conf.output.ExecuteString(map[string]interface{}{
 				"key":     issue.Key,
 				"url":     createLink(issue),
 				"summary": issue.Fields.Summary,
-				"status":  issue.Fields.Status.Name})}); err != nil {
+				"status":  issue.Fields.Status.Name})})
+		if err != nil {
 			return err
 		}
-		if err := ctx.Bus.Publish(*event); err != nil {
+		if err := ctx.Publish(*event); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// issue gets issue by key. JIRA answering 4xx (unknown key, no access)
+// is not an outage, such errors are permanent and do not trip the breaker.
+func (p *jiraResolver) issue(client *jira.Client, key string) (*jira.Issue, error) {
+	var issue *jira.Issue
+	err := p.breaker.Do(func() error {
+		i, resp, err := client.Issue.Get(key, nil)
+		if err != nil && resp != nil && resp.StatusCode < http.StatusInternalServerError {
+			return bus.Permanent(err)
+		}
+		issue = i
+		return err
+	})
+	return issue, err
+}
+
 type jiraCommenter struct {
+	lock    sync.Mutex
 	output  *fasttemplate.Template
 	channel string
 }
 
-func (p *jiraCommenter) Run(ctx bus.Context) error {
+func (p *jiraCommenter) Schema() config.Schema {
+	return config.Schema{
+		"channel":         {Type: config.StringType, Required: true},
+		"output-template": {Type: config.StringType, Required: true}}
+}
+
+// Reload applies new channel and output-template.
+func (p *jiraCommenter) Reload(ctx bus.Context) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
 	p.channel = ctx.Config.GetStringOr("channel", "")
 	p.output = fasttemplate.New(ctx.Config.GetStringOr("output-template", ""), "{{", "}}")
+	return nil
+}
+
+func (p *jiraCommenter) Run(ctx bus.Context) error {
+	p.Reload(ctx)
 	ctx.Bus.Subscribe(bus.JiraHookEvent, bus.Context{
 		Func:   p.handler,
-		Name:   "JiraCommentHandler",
+		Name:   ctx.Named("JiraCommentHandler"),
 		Bus:    ctx.Bus,
 		Config: ctx.Config})
 
@@ -172,13 +255,17 @@ func (p *jiraCommenter) handler(e bus.Event, ctx bus.Context) error {
 		return err
 	}
 
+	p.lock.Lock()
+	output, channel := p.output, p.channel
+	p.lock.Unlock()
+
 	msg := slackMessage{
-		Text: p.output.ExecuteString(map[string]interface{}{
+		Text: output.ExecuteString(map[string]interface{}{
 			"key":     issue.Key,
 			"url":     createLink(&issue),
 			"summary": issue.Fields.Summary,
 			"status":  issue.Fields.Status.Name}),
-		Channel: p.channel,
+		Channel: channel,
 		Attachments: []slack.Attachment{
 			slack.Attachment{
 				Color:      "#008000",
@@ -190,9 +277,9 @@ func (p *jiraCommenter) handler(e bus.Event, ctx bus.Context) error {
 						Value: comment.Body,
 						Short: false}}}}}
 
-	if event, err := bus.NewEventWithData(e.Trace, bus.SlackPostEvent, bus.JsonCoding, msg); err != nil {
+	if event, err := e.DeriveWithData(bus.SlackPostEvent, bus.JsonCoding, msg); err != nil {
 		return err
 	} else {
-		return ctx.Bus.Publish(*event)
+		return ctx.Publish(*event)
 	}
 }
@@ -0,0 +1,49 @@
+package tasks
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mhanygin/broforce/bus"
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+)
+
+func TestJiraIssueBreaker(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("/tmp", "config_")
+	assert.NoError(t, err)
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+	c, err := config.Load(tmpfile.Name(), config.YAMLAdapter)
+	assert.NoError(t, err)
+	logger.New(c.Get("logger"))
+
+	status := http.StatusNotFound
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	client, err := jira.NewClient(nil, server.URL)
+	assert.NoError(t, err)
+
+	p := &jiraResolver{breaker: bus.NewBreaker("jira", nil, bus.Context{})}
+	for i := 0; i < 10; i++ {
+		_, err := p.issue(client, "UNKNOWN-1")
+		assert.Error(t, err)
+		assert.True(t, bus.IsPermanent(err))
+	}
+	assert.Equal(t, bus.ClosedState, p.breaker.State())
+
+	status = http.StatusBadGateway
+	for i := 0; i < 5; i++ {
+		p.issue(client, "BRO-1")
+	}
+	assert.Equal(t, bus.OpenState, p.breaker.State())
+}
@@ -7,16 +7,18 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
 
 	"github.com/Jeffail/gabs"
 	"github.com/google/go-github/github"
 	"github.com/xanzy/go-gitlab"
 
 	"github.com/mhanygin/broforce/bus"
+	"github.com/mhanygin/broforce/config"
 )
 
 func init() {
-	registry("manifest", bus.Task(&manifest{}))
+	registry("manifest", func() bus.Task { return &manifest{} })
 }
 
 //config section
@@ -43,6 +45,10 @@ const (
 )
 
 type manifest struct {
+	lock   sync.Mutex
+	cfg    config.ConfigData
+	gitlab *bus.Breaker
+	github *bus.Breaker
 }
 
 type serveParams struct {
@@ -52,22 +58,53 @@ type serveParams struct {
 	Ref      string
 }
 
+func (p *manifest) Schema() config.Schema {
+	plugins := &config.Field{Type: config.StringType}
+	git := config.Schema{
+		"host":  {Type: config.StringType, Required: true},
+		"token": {Type: config.StringType, Required: true}}
+	return config.Schema{
+		"plugins": {Type: config.MapType, Required: true, Fields: config.Schema{
+			"change": {Type: config.ListType, Items: plugins},
+			"delete": {Type: config.ListType, Items: plugins}}},
+		"gitlab": {Type: config.MapType, Fields: git},
+		"github": {Type: config.MapType, Fields: git}}
+}
+
+func (p *manifest) config() config.ConfigData {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.cfg
+}
+
+// Reload applies new plugins and gitlab/github credentials.
+func (p *manifest) Reload(ctx bus.Context) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.cfg = ctx.Config
+	return nil
+}
+
 func (p *manifest) Run(ctx bus.Context) error {
+	p.Reload(ctx)
+	p.gitlab = bus.NewBreaker("gitlab", ctx.Config.Get("breaker"), ctx)
+	p.github = bus.NewBreaker("github", ctx.Config.Get("breaker"), ctx)
 	ctx.Bus.Subscribe(bus.GitlabHookEvent, bus.Context{
 		Func:   p.handlerGitlab,
-		Name:   "GitLabHandler",
+		Name:   ctx.Named("GitLabHandler"),
 		Bus:    ctx.Bus,
 		Config: ctx.Config})
 	ctx.Bus.Subscribe(bus.GithubHookEvent, bus.Context{
 		Func:   p.handlerGithub,
-		Name:   "GitHubHandler",
+		Name:   ctx.Named("GitHubHandler"),
 		Bus:    ctx.Bus,
 		Config: ctx.Config})
 	return nil
 }
 
 func (p *manifest) handlerGitlab(e bus.Event, ctx bus.Context) error {
-	var host, token = ctx.Config.GetString("gitlab.host"), ctx.Config.GetString("gitlab.token")
+	cfg := p.config()
+	var host, token = cfg.GetString("gitlab.host"), cfg.GetString("gitlab.token")
 	params := serveParams{Vars: map[string]string{"purge": "false"}}
 
 	ctx.Log.Infof("%v %v", token, host)
@@ -119,39 +156,44 @@ func (p *manifest) handlerGitlab(e bus.Event, ctx bus.Context) error {
 		}
 	}
 
-	if params.Manifest, err = p.uploadGitlabManifest(host, token, fmt.Sprintf("%v", projectId), params.Ref, manifestName); err != nil {
+	if err := p.gitlab.Do(func() (err error) {
+		params.Manifest, err = p.uploadGitlabManifest(host, token, fmt.Sprintf("%v", projectId), params.Ref, manifestName)
+		return err
+	}); err != nil {
 		return err
 	}
 
 	if strings.Compare(params.Vars["purge"], "true") == 0 {
-		p.pusher(e.Trace, ctx.Config.GetArrayString("plugins.delete"), params, &ctx)
+		p.pusher(&e, cfg.GetArrayString("plugins.delete"), params, &ctx)
 	} else {
-		p.pusher(e.Trace, ctx.Config.GetArrayString("plugins.change"), params, &ctx)
+		p.pusher(&e, cfg.GetArrayString("plugins.change"), params, &ctx)
 	}
 	return nil
 }
 
-func (p *manifest) pusher(uuid string, plugins []string, params serveParams, ctx *bus.Context) {
-	event := bus.NewEvent(uuid, bus.ServeCmdEvent, bus.JsonCoding)
+func (p *manifest) pusher(e *bus.Event, plugins []string, params serveParams, ctx *bus.Context) {
 	for _, plugin := range plugins {
 		params.Plugin = plugin
-		if err := event.Marshal(params); err != nil {
+		event, err := e.DeriveWithData(bus.ServeCmdEvent, bus.JsonCoding, params)
+		if err != nil {
 			ctx.Log.Error(err)
 			continue
 		}
-		if err := ctx.Bus.Publish(*event); err != nil {
+		event.SetHeader(bus.OrderKeyHeader, fmt.Sprintf("%s/%s", params.Vars["ssh-repo"], params.Vars["branch"]))
+		if err := ctx.Publish(*event); err != nil {
 			ctx.Log.Error(err)
 		}
 	}
 }
 
 func (p *manifest) handlerGithub(e bus.Event, ctx bus.Context) error {
+	cfg := p.config()
 	g, err := gabs.ParseJSON(e.Data)
 	if err != nil {
 		return err
 	}
 
-	var host, token = ctx.Config.GetString("github.host"), ctx.Config.GetString("github.token")
+	var host, token = cfg.GetString("github.host"), cfg.GetString("github.token")
 	params := serveParams{Vars: map[string]string{"purge": "false"}}
 
 	ctx.Log.Infof("%v %v", token, host)
@@ -198,14 +240,17 @@ func (p *manifest) handlerGithub(e bus.Event, ctx bus.Context) error {
 			}
 		}
 	}
-	if params.Manifest, err = p.uploadGithubManifest(host, token, repo, params.Ref, manifestName); err != nil {
+	if err := p.github.Do(func() (err error) {
+		params.Manifest, err = p.uploadGithubManifest(host, token, repo, params.Ref, manifestName)
+		return err
+	}); err != nil {
 		return err
 	}
 
 	if strings.Compare(params.Vars["purge"], "true") == 0 {
-		p.pusher(e.Trace, ctx.Config.GetArrayString("plugins.delete"), params, &ctx)
+		p.pusher(&e, cfg.GetArrayString("plugins.delete"), params, &ctx)
 	} else {
-		p.pusher(e.Trace, ctx.Config.GetArrayString("plugins.change"), params, &ctx)
+		p.pusher(&e, cfg.GetArrayString("plugins.change"), params, &ctx)
 	}
 	return nil
 }
@@ -11,10 +11,11 @@ import (
 	"time"
 
 	"github.com/mhanygin/broforce/bus"
+	"github.com/mhanygin/broforce/config"
 )
 
 func init() {
-	registry("runner", bus.Task(&runner{}))
+	registry("runner", func() bus.Task { return &runner{} })
 }
 
 //config section
@@ -35,7 +36,7 @@ func (p *runner) handler(script string) bus.Handler {
 	return func(e bus.Event, ctx bus.Context) error {
 		buffer := bytes.NewBuffer(make([]byte, 0))
 		w := io.Writer(buffer)
-		cmd := exec.Command("env", fmt.Sprintf("BROFORCE_EVENT='%s'", string(e.Data)), script)
+		cmd := exec.CommandContext(ctx.Ctx, "env", fmt.Sprintf("BROFORCE_EVENT='%s'", string(e.Data)), script)
 		ctx.Log.Debug(strings.Join(cmd.Args, " "))
 		cmd.Stdout = w
 		cmd.Stderr = w
@@ -45,6 +46,13 @@ func (p *runner) handler(script string) bus.Handler {
 	}
 }
 
+func (p *runner) Schema() config.Schema {
+	return config.Schema{
+		"path": {Type: config.StringType, Default: "./"},
+		"map": {Type: config.MapType, Fields: config.Schema{
+			config.AnyKey: {Type: config.ListType, Items: &config.Field{Type: config.StringType}}}}}
+}
+
 func (p *runner) Run(ctx bus.Context) error {
 	path := ctx.Config.GetStringOr("path", "./")
 	for k, _ := range ctx.Config.GetMap("map") {
@@ -53,7 +61,7 @@ func (p *runner) Run(ctx bus.Context) error {
 			ctx.Log.Debug(filepath.Join(path, script))
 			ctx.Bus.Subscribe(k, bus.Context{
 				Func:   p.handler(filepath.Join(path, script)),
-				Name:   fmt.Sprintf("%s%c%s", path, os.PathSeparator, script),
+				Name:   ctx.Named(fmt.Sprintf("%s%c%s", path, os.PathSeparator, script)),
 				Bus:    ctx.Bus,
 				Config: ctx.Config})
 		}
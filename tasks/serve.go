@@ -2,6 +2,7 @@ package tasks
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,10 +11,11 @@ import (
 	"strings"
 
 	"github.com/mhanygin/broforce/bus"
+	"github.com/mhanygin/broforce/config"
 )
 
 func init() {
-	registry("serve", bus.Task(&serve{}))
+	registry("serve", func() bus.Task { return &serve{} })
 }
 
 //config section
@@ -22,7 +24,7 @@ func init() {
 type serve struct {
 }
 
-func (p *serve) serveRun(params *serveParams, event *bus.Event, w io.Writer) error {
+func (p *serve) serveRun(ctx context.Context, params *serveParams, event *bus.Event, w io.Writer) error {
 	args := []string{params.Plugin}
 	for k, v := range params.Vars {
 		args = append(args, "--var", fmt.Sprintf("%s=%s", k, v))
@@ -42,34 +44,39 @@ func (p *serve) serveRun(params *serveParams, event *bus.Event, w io.Writer) err
 	case event.SubjectIs(bus.ServeCmdWithDataEvent):
 		args = append(args, fmt.Sprintf("--plugin-data=%s", strings.Replace(string(params.Manifest), "\n", "", -1)))
 	}
-	cmd := exec.Command("serve", args...)
+	cmd := exec.CommandContext(ctx, "serve", args...)
 	w.Write([]byte(strings.Join(cmd.Args, " ")))
 	cmd.Stdout = w
 	cmd.Stderr = w
 	return cmd.Run()
 }
 
+func (p *serve) Schema() config.Schema {
+	return config.Schema{}
+}
+
+// Run subscribes one handler to SERVE and SERVE_WITH_DATA, so deploys
+// and purges with the same order key are run one by one in order.
 func (p *serve) Run(ctx bus.Context) error {
-	ctx.Bus.Subscribe(bus.ServeCmdEvent, bus.Context{
-		Func:   p.handler,
-		Name:   "ServeHandler",
-		Bus:    ctx.Bus,
-		Config: ctx.Config})
-	ctx.Bus.Subscribe(bus.ServeCmdWithDataEvent, bus.Context{
-		Func:   p.handler,
-		Name:   "ServeHandler",
-		Bus:    ctx.Bus,
-		Config: ctx.Config})
+	ctx.Bus.Subscribe("serve.*", bus.Context{
+		Func:    p.handler,
+		Name:    ctx.Named("ServeHandler"),
+		Bus:     ctx.Bus,
+		Config:  ctx.Config,
+		OrderBy: bus.ByHeader(bus.OrderKeyHeader)})
 	return nil
 }
 
 func (p *serve) handler(e bus.Event, ctx bus.Context) error {
+	if !e.SubjectIs(bus.ServeCmdEvent) && !e.SubjectIs(bus.ServeCmdWithDataEvent) {
+		return nil
+	}
 	params := &serveParams{}
 	if err := e.Unmarshal(params); err != nil {
 		return err
 	}
 	buffer := bytes.NewBuffer(make([]byte, 0))
-	err := p.serveRun(params, &e, io.Writer(buffer))
+	err := p.serveRun(ctx.Ctx, params, &e, io.Writer(buffer))
 	ctx.Log.Info(buffer.String())
 	return err
 }
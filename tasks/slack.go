@@ -3,14 +3,16 @@ package tasks
 import (
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/nlopes/slack"
 
 	"github.com/mhanygin/broforce/bus"
+	"github.com/mhanygin/broforce/config"
 )
 
 func init() {
-	registry("slackSensor", bus.Task(&sensorSlack{}))
+	registry("slackSensor", func() bus.Task { return &sensorSlack{} })
 }
 
 //config section
@@ -18,6 +20,7 @@ func init() {
 //slackSensor:
 //  username: "UUID user"
 //  token: TOKEN
+//  circuit-channel: slack_channel_name
 //
 
 type slackMessage slack.Msg
@@ -25,6 +28,8 @@ type slackMessage slack.Msg
 type sensorSlack struct {
 	client *slack.Client
 	user   *slack.User
+	lock   sync.Mutex
+	health error
 }
 
 func (p *sensorSlack) messageEvent(msg *slack.MessageEvent, ctx *bus.Context) error {
@@ -40,7 +45,7 @@ func (p *sensorSlack) messageEvent(msg *slack.MessageEvent, ctx *bus.Context) er
 		return err
 	} else {
 		ctx.Log.Debugf("Push: %s", uuid)
-		return ctx.Bus.Publish(*event)
+		return ctx.Publish(*event)
 	}
 }
 
@@ -62,7 +67,32 @@ func (p *sensorSlack) postMessage(e bus.Event, ctx bus.Context) error {
 	return err
 }
 
-func (p *sensorSlack) Run(ctx bus.Context) error {
+func (p *sensorSlack) circuitMessage(e bus.Event, ctx bus.Context) error {
+	cs := bus.CircuitState{}
+	if err := e.Unmarshal(&cs); err != nil {
+		return err
+	}
+	if cs.State == bus.HalfOpenState {
+		return nil
+	}
+	text := fmt.Sprintf("%s: circuit %s", cs.Name, cs.State)
+	if len(cs.Error) != 0 {
+		text = fmt.Sprintf("%s after %d failures: %s", text, cs.Failures, cs.Error)
+	}
+	_, _, err := p.client.PostMessage(ctx.Config.GetString("circuit-channel"), text, slack.PostMessageParameters{
+		AsUser:   true,
+		Username: p.user.ID})
+	return err
+}
+
+func (p *sensorSlack) Schema() config.Schema {
+	return config.Schema{
+		"username":        {Type: config.StringType, Required: true},
+		"token":           {Type: config.StringType, Required: true},
+		"circuit-channel": {Type: config.StringType}}
+}
+
+func (p *sensorSlack) Init(ctx bus.Context) error {
 	p.client = slack.New(ctx.Config.GetStringOr("token", ""))
 
 	if user, err := p.client.GetUserInfo(ctx.Config.GetString("username")); err != nil {
@@ -73,14 +103,25 @@ func (p *sensorSlack) Run(ctx bus.Context) error {
 
 	ctx.Log.Infof("Ignore user: %s", p.user.ID)
 
-	rtm := p.client.NewRTM()
-	go rtm.ManageConnection()
-
 	ctx.Bus.Subscribe(bus.SlackPostEvent, bus.Context{
 		Func:   p.postMessage,
-		Name:   "SlackHandler",
+		Name:   ctx.Named("SlackHandler"),
 		Bus:    ctx.Bus,
 		Config: ctx.Config})
+	if ctx.Config.Exist("circuit-channel") {
+		ctx.Bus.Subscribe(bus.CircuitEvent, bus.Context{
+			Func:   p.circuitMessage,
+			Name:   ctx.Named("SlackCircuitHandler"),
+			Bus:    ctx.Bus,
+			Config: ctx.Config})
+	}
+	return nil
+}
+
+func (p *sensorSlack) Run(ctx bus.Context) error {
+	rtm := p.client.NewRTM()
+	go rtm.ManageConnection()
+	defer rtm.Disconnect()
 
 	for {
 		select {
@@ -92,10 +133,30 @@ func (p *sensorSlack) Run(ctx bus.Context) error {
 				}
 			case *slack.RTMError:
 				ctx.Log.Error(ev.Error())
+				p.setHealth(ev)
+			case *slack.ConnectedEvent:
+				p.setHealth(nil)
 			case *slack.InvalidAuthEvent:
 				return fmt.Errorf("Invalid credentials")
 			}
+		case <-ctx.Ctx.Done():
+			return nil
 		}
 	}
+}
+
+func (p *sensorSlack) Stop(ctx bus.Context) error {
 	return nil
 }
+
+func (p *sensorSlack) setHealth(err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.health = err
+}
+
+func (p *sensorSlack) Health() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.health
+}
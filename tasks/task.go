@@ -2,22 +2,39 @@ package tasks
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/mhanygin/broforce/bus"
+	"github.com/mhanygin/broforce/config"
 )
 
-var tasksPool = make(map[string]bus.Task)
+//config section
+//
+//instances:
+//  - name: slack-main
+//    type: slackSensor
+//    token: TOKEN
+//  - name: hook-internal
+//    type: hookSensor
+//    port: 8083
+//
 
-func registry(name string, task bus.Task) {
+// Factory creates fresh value of task, every instance of task type
+// gets its own one.
+type Factory func() bus.Task
+
+var tasksPool = make(map[string]Factory)
+
+func registry(name string, factory Factory) {
 	if _, ok := tasksPool[name]; ok {
 		panic(fmt.Errorf("Task %s already registry", name))
 	} else {
-		tasksPool[name] = task
+		tasksPool[name] = factory
 	}
 }
 
-func GetPool() map[string]bus.Task {
+func GetPool() map[string]Factory {
 	return tasksPool
 }
 
@@ -29,3 +46,58 @@ func GetPoolString() string {
 
 	return strings.Join(keys, ",")
 }
+
+type Instance struct {
+	Name   string
+	Type   string
+	Path   string
+	Config config.ConfigData
+	Task   bus.Task
+}
+
+// GetInstances returns allowed tasks: one instance per task type named
+// by the type with config section of the same name, and instances from
+// `instances` section. Type without own section, but with named
+// instances, runs only as those instances. Instance is allowed when
+// allow contains its name or type.
+func GetInstances(c config.Config, allow string) ([]Instance, error) {
+	allowed := func(names ...string) bool {
+		for _, n := range names {
+			if strings.Index(fmt.Sprintf(",%s,", allow), fmt.Sprintf(",%s,", n)) != -1 {
+				return true
+			}
+		}
+		return false
+	}
+
+	instances := make([]Instance, 0)
+	names := make(map[string]bool)
+	typed := make(map[string]bool)
+	for i, cfg := range c.Get("instances").GetArray("") {
+		name, t := cfg.GetStringOr("name", ""), cfg.GetStringOr("type", "")
+		factory, ok := tasksPool[t]
+		switch {
+		case len(name) == 0:
+			return nil, fmt.Errorf("instance of %s without name", t)
+		case !ok:
+			return nil, fmt.Errorf("instance %s: unknown type %s", name, t)
+		case names[name] || (tasksPool[name] != nil && name != t):
+			return nil, fmt.Errorf("instance %s: duplicate name", name)
+		}
+		names[name], typed[t] = true, true
+		if allowed(name, t) {
+			instances = append(instances, Instance{Name: name, Type: t, Path: fmt.Sprintf("instances[%d]", i), Config: cfg, Task: factory()})
+		}
+	}
+
+	for t, factory := range tasksPool {
+		if names[t] || (typed[t] && !c.Exist(t)) || !allowed(t) {
+			continue
+		}
+		instances = append(instances, Instance{Name: t, Type: t, Path: t, Config: c.Get(t), Task: factory()})
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].Name < instances[j].Name
+	})
+	return instances, nil
+}
@@ -1,16 +1,119 @@
 package tasks
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/mhanygin/broforce/bus"
+	"github.com/mhanygin/broforce/config"
 )
 
 func TestGetPool(t *testing.T) {
-	tasksPool = make(map[string]bus.Task)
-	registry("test", bus.Task(nil))
+	saved := tasksPool
+	defer func() { tasksPool = saved }()
+
+	tasksPool = make(map[string]Factory)
+	registry("test", func() bus.Task { return nil })
 
 	assert.Equal(t, len(GetPool()), 1)
 }
+
+func TestGetInstances(t *testing.T) {
+	data := `serve:
+  pool:
+    workers: 2
+
+instances:
+  - name: hook-main
+    type: hookSensor
+    port: 8081
+  - name: hook-internal
+    type: hookSensor
+    port: 8082
+  - name: serve-fast
+    type: serve
+`
+	tmpfile, err := ioutil.TempFile("/tmp", "config_")
+	if err != nil {
+		t.Error(err)
+		t.Fail()
+	}
+	defer os.Remove(tmpfile.Name())
+	if _, err := tmpfile.Write([]byte(data)); err != nil {
+		t.Error(err)
+		t.Fail()
+	}
+	tmpfile.Close()
+	c := config.New(tmpfile.Name(), config.YAMLAdapter)
+
+	instances, err := GetInstances(c, "hookSensor,serve")
+	assert.NoError(t, err)
+
+	names := make([]string, 0)
+	for _, i := range instances {
+		names = append(names, i.Name)
+	}
+	assert.Equal(t, []string{"hook-internal", "hook-main", "serve", "serve-fast"}, names)
+	assert.Equal(t, "hookSensor", instances[0].Type)
+	assert.Equal(t, 8082, instances[0].Config.GetInt("port"))
+	assert.Equal(t, 2, instances[2].Config.GetInt("pool.workers"))
+	assert.False(t, instances[0].Task == instances[1].Task)
+
+	instances, err = GetInstances(c, "hook-main")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(instances))
+}
+
+func TestCheck(t *testing.T) {
+	data := `bus:
+  simple: {}
+
+jiraResolver:
+  jira-host: https://jira.com
+  jira-user: robot
+  input-template: "([A-Z]+-[0-9]+"
+  output-template: "{{key}}"
+  retry:
+    retry: many
+
+jiraResolvr:
+  jira-password: secret
+
+instances:
+  - name: hook-main
+    type: hookSensor
+    port: eighty
+  - name: slack-main
+    type: slackSensor
+    token: TOKEN
+    username: broforce
+`
+	tmpfile, err := ioutil.TempFile("/tmp", "config_")
+	assert.NoError(t, err)
+	defer os.Remove(tmpfile.Name())
+	tmpfile.WriteString(data)
+	tmpfile.Close()
+	c, err := config.Load(tmpfile.Name(), config.YAMLAdapter)
+	assert.NoError(t, err)
+
+	errs := make([]string, 0)
+	warnings := make([]string, 0)
+	for _, err := range Check(c, GetPoolString(), "bus") {
+		if config.IsWarning(err) {
+			warnings = append(warnings, err.Error())
+		} else {
+			errs = append(errs, err.Error())
+		}
+	}
+	assert.Equal(t, []string{
+		"instances[0].port: int expected, got \"eighty\"",
+		"jiraResolver.input-template: error parsing regexp: missing closing ): `([A-Z]+-[0-9]+`",
+		"jiraResolver.jira-password: required",
+		"jiraResolver.retry.retry: int expected, got \"many\""}, errs)
+	assert.Equal(t, []string{"jiraResolvr: unknown section"}, warnings)
+
+	assert.Empty(t, Check(c, "slack-main", "bus", "jiraResolvr"))
+}
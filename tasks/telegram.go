@@ -6,10 +6,11 @@ import (
 	"gopkg.in/telegram-bot-api.v4"
 
 	"github.com/mhanygin/broforce/bus"
+	"github.com/mhanygin/broforce/config"
 )
 
 func init() {
-	registry("telegramSensor", bus.Task(&sensorTelegram{}))
+	registry("telegramSensor", func() bus.Task { return &sensorTelegram{} })
 }
 
 //config section
@@ -40,7 +41,7 @@ func (p *sensorTelegram) messageEvent(msg tgbotapi.Update, ctx *bus.Context) err
 		return err
 	} else {
 		ctx.Log.Debugf("Push: %s", uuid)
-		return ctx.Bus.Publish(*event)
+		return ctx.Publish(*event)
 	}
 }
 
@@ -48,6 +49,13 @@ func (p *sensorTelegram) postMessage(e bus.Event, ctx bus.Context) error {
 	return nil
 }
 
+func (p *sensorTelegram) Schema() config.Schema {
+	return config.Schema{
+		"token":         {Type: config.StringType, Required: true},
+		"chat_id":       {Type: config.IntType},
+		"allowed_users": {Type: config.ListType, Items: &config.Field{Type: config.StringType}}}
+}
+
 func (p *sensorTelegram) Run(ctx bus.Context) error {
 	var err error
 	p.client, err = tgbotapi.NewBotAPI(ctx.Config.GetStringOr("token", ""))
@@ -61,11 +69,19 @@ func (p *sensorTelegram) Run(ctx bus.Context) error {
 
 	allowedUsers := ctx.Config.GetArrayString("allowed_users")
 
-	for update := range updates {
-		if (update.Message == nil) || !p.validator(update.Message, allowedUsers) {
-			continue
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return nil
+			}
+			if (update.Message == nil) || !p.validator(update.Message, allowedUsers) {
+				continue
+			}
+			p.messageEvent(update, &ctx)
+		case <-ctx.Ctx.Done():
+			p.client.StopReceivingUpdates()
+			return nil
 		}
-		p.messageEvent(update, &ctx)
 	}
-	return nil
 }
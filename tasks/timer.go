@@ -7,7 +7,7 @@ import (
 )
 
 func init() {
-	//registry("timer", bus.Task(&timer{}))
+	//registry("timer", func() bus.Task { return &timer{} })
 }
 
 //config section
@@ -38,7 +38,7 @@ func (p *timer) Run(ctx bus.Context) error {
 	p.interval = time.Duration(ctx.Config.GetIntOr("interval", 1)) * time.Second
 	ctx.Bus.Subscribe(bus.TimerEvent, bus.Context{
 		Func:   p.handler,
-		Name:   "TimerHandler",
+		Name:   ctx.Named("TimerHandler"),
 		Bus:    ctx.Bus,
 		Config: ctx.Config})
 	i := int64(0)
@@ -51,14 +51,15 @@ func (p *timer) Run(ctx bus.Context) error {
 		} else {
 			ctx.Log.Debugf("Push: %s", uuid)
 
-			if err := ctx.Bus.Publish(*event); err != nil {
+			if err := ctx.Publish(*event); err != nil {
 				ctx.Log.Error(err)
 			}
 		}
-		time.Sleep(p.interval)
+		select {
+		case <-ctx.Ctx.Done():
+			ctx.Log.Debug("timer Complete")
+			return nil
+		case <-time.After(p.interval):
+		}
 	}
-
-	ctx.Log.Debug("timer Complete")
-
-	return nil
 }
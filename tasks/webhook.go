@@ -1,37 +1,65 @@
 package tasks
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Jeffail/gabs"
 
 	"github.com/mhanygin/broforce/bus"
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/metrics"
 	"os"
 	"strconv"
 )
 
 func init() {
-	registry("hookSensor", bus.Task(&hookSensor{}))
+	registry("hookSensor", func() bus.Task { return &hookSensor{} })
 }
 
 const (
-	maxRetry       = 10
-	envWebhookPort = "BROFORSE_WEBHOOK_PORT"
-	defaultDelay   = 10
-	defaultPort    = 8080
+	defaultMaxDelay        = 600
+	defaultShutdownTimeout = 10
+	envWebhookPort         = "BROFORSE_WEBHOOK_PORT"
+	defaultDelay           = 10
+	defaultPort            = 8080
 )
 
+var deliveryHeaders = []string{
+	"X-GitHub-Delivery",
+	"X-Gitlab-Event-UUID",
+	"X-Atlassian-Webhook-Identifier",
+}
+
+func newHookEvent(r *http.Request, subject string, body []byte) *bus.Event {
+	event := bus.NewEvent(bus.NewUUID(), subject, bus.JsonCoding)
+	event.Data = body
+	for _, h := range deliveryHeaders {
+		if id := r.Header.Get(h); len(id) != 0 {
+			event.Headers[bus.DeliveryHeader] = id
+			break
+		}
+	}
+	return event
+}
+
 type hookSensor struct {
 	gitParams  map[string]string
 	jiraParams map[string]string
 	ctx        *bus.Context
+	mux        *http.ServeMux
+	server     *http.Server
+	lock       sync.Mutex
+	health     error
 }
 
-func (p hookSensor) selector(body []byte) (string, error) {
+func (p *hookSensor) selector(body []byte) (string, error) {
 	g, err := gabs.ParseJSON(body)
 	if err != nil {
 		return bus.UnknownEvent, err
@@ -73,15 +101,11 @@ func (p *hookSensor) git(w http.ResponseWriter, r *http.Request) {
 		if gitType, err := p.selector(body); err != nil {
 			p.ctx.Log.Error(err)
 		} else {
-			uuid := bus.NewUUID()
+			event := newHookEvent(r, gitType, body)
 
-			p.ctx.Log.Debugf("Push: %s", uuid)
+			p.ctx.Log.Debugf("Push: %s", event.Trace)
 
-			if err := p.ctx.Bus.Publish(bus.Event{
-				Trace:   uuid,
-				Subject: gitType,
-				Coding:  bus.JsonCoding,
-				Data:    body}); err != nil {
+			if err := p.ctx.Publish(*event); err != nil {
 				p.ctx.Log.Error(err)
 			}
 		}
@@ -104,23 +128,35 @@ func (p *hookSensor) jira(w http.ResponseWriter, r *http.Request) {
 	if body, err := ioutil.ReadAll(r.Body); err != nil {
 		p.ctx.Log.Error(err)
 	} else {
-		uuid := bus.NewUUID()
+		event := newHookEvent(r, bus.JiraHookEvent, body)
 
-		p.ctx.Log.Debugf("Push: %s", uuid)
+		p.ctx.Log.Debugf("Push: %s", event.Trace)
 
-		if err := p.ctx.Bus.Publish(bus.Event{
-			Trace:   uuid,
-			Subject: bus.JiraHookEvent,
-			Coding:  bus.JsonCoding,
-			Data:    body}); err != nil {
+		if err := p.ctx.Publish(*event); err != nil {
 			p.ctx.Log.Error(err)
 		}
 	}
 	return
 }
 
-func (p *hookSensor) Run(ctx bus.Context) error {
+func (p *hookSensor) Schema() config.Schema {
+	endpoint := func(url string) config.Field {
+		return config.Field{Type: config.MapType, Fields: config.Schema{
+			"url":            {Type: config.StringType, Default: url},
+			"auth-key-name":  {Type: config.StringType},
+			"auth-key-value": {Type: config.StringType}}}
+	}
+	return config.Schema{
+		"port":             {Type: config.IntType, Default: defaultPort},
+		"delay":            {Type: config.IntType, Default: defaultDelay},
+		"shutdown-timeout": {Type: config.IntType, Default: defaultShutdownTimeout},
+		"git":              endpoint("/git"),
+		"jira":             endpoint("/jira")}
+}
+
+func (p *hookSensor) Init(ctx bus.Context) error {
 	p.ctx = &ctx
+	p.mux = http.NewServeMux()
 
 	if p.ctx.Config.Exist("git") {
 		p.ctx.Log.Debugf("add git handler with params: %v", p.ctx.Config.GetMap("git"))
@@ -128,7 +164,8 @@ func (p *hookSensor) Run(ctx bus.Context) error {
 		p.gitParams = make(map[string]string)
 		p.gitParams["AuthKeyName"] = p.ctx.Config.GetStringOr("git.auth-key-name", "")
 		p.gitParams["AuthKeyValue"] = p.ctx.Config.GetStringOr("git.auth-key-value", "")
-		http.HandleFunc(p.ctx.Config.GetStringOr("git.url", "/git"), p.git)
+		url := p.ctx.Config.GetStringOr("git.url", "/git")
+		p.mux.HandleFunc(url, metrics.Webhook(url, p.git))
 	}
 
 	if p.ctx.Config.Exist("jira") {
@@ -137,38 +174,69 @@ func (p *hookSensor) Run(ctx bus.Context) error {
 		p.jiraParams = make(map[string]string)
 		p.jiraParams["AuthKeyName"] = p.ctx.Config.GetStringOr("jira.auth-key-name", "")
 		p.jiraParams["AuthKeyValue"] = p.ctx.Config.GetStringOr("jira.auth-key-value", "")
-		http.HandleFunc(p.ctx.Config.GetStringOr("jira.url", "/jira"), p.jira)
+		url := p.ctx.Config.GetStringOr("jira.url", "/jira")
+		p.mux.HandleFunc(url, metrics.Webhook(url, p.jira))
 	}
 
-	p.ctx.Log.Debug("Run")
-
-	delay := time.Duration(p.ctx.Config.GetIntOr("delay", defaultDelay))
+	// environment overrides port of the unnamed instance only
 	port, err := strconv.Atoi(os.Getenv(envWebhookPort))
-	if err != nil {
-		p.ctx.Log.Error(err)
+	if err != nil || (len(ctx.Type) != 0 && ctx.Name != ctx.Type) {
 		port = p.ctx.Config.GetIntOr("port", defaultPort)
 	}
 
 	p.ctx.Log.Debugf("PORT: %d", port)
 
-	i := 0
-	for {
-		if err := http.ListenAndServe(fmt.Sprintf(":%d", port), nil); err != nil {
-			p.ctx.Log.Debug(err)
+	p.server = &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: p.mux}
+	return nil
+}
 
-			time.Sleep(delay * time.Second)
-			i++
-			if i >= maxRetry {
-				i = 0
-				delay = 2 * delay
-				p.ctx.Log.Infof("new delay %v", delay)
-			}
-		} else {
-			break
+func (p *hookSensor) Run(ctx bus.Context) error {
+	p.ctx.Log.Debug("Run")
+
+	delay := time.Duration(p.ctx.Config.GetIntOr("delay", defaultDelay))
+	retry := bus.NewSafeParams(p.ctx.Config.Get("retry"), bus.SafeParams{
+		Retry:      -1,
+		Delay:      delay * time.Second,
+		MaxDelay:   defaultMaxDelay * time.Second,
+		Multiplier: 2,
+		Jitter:     0.1})
+	retry.DoContext(ctx.Ctx, func(attempt int) error {
+		ln, err := net.Listen("tcp", p.server.Addr)
+		if err != nil {
+			p.ctx.Log.Debug(err)
+			p.setHealth(err)
+			return err
 		}
-	}
+		p.setHealth(nil)
+		err = p.server.Serve(ln)
+		if err == http.ErrServerClosed {
+			return nil
+		}
+		p.ctx.Log.Debug(err)
+		p.setHealth(err)
+		return err
+	})
 
 	p.ctx.Log.Debug("Complete")
 
 	return nil
 }
+
+func (p *hookSensor) Stop(ctx bus.Context) error {
+	shutdown, cancel := context.WithTimeout(context.Background(),
+		time.Duration(p.ctx.Config.GetIntOr("shutdown-timeout", defaultShutdownTimeout))*time.Second)
+	defer cancel()
+	return p.server.Shutdown(shutdown)
+}
+
+func (p *hookSensor) setHealth(err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.health = err
+}
+
+func (p *hookSensor) Health() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.health
+}
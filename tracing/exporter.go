@@ -0,0 +1,185 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+)
+
+//config section
+//
+//tracing:
+//  file: /var/log/broforce/spans.json
+//  endpoint: http://127.0.0.1:4318/v1/traces
+//  service: broforce
+//  batch-size: 100
+//  interval: 5
+//
+
+const (
+	defaultService   = "broforce"
+	defaultBatchSize = 100
+	defaultInterval  = 5
+	queueSize        = 10000
+)
+
+type exporter struct {
+	service  string
+	file     string
+	endpoint string
+	batch    int
+	interval time.Duration
+	spans    chan *Span
+	done     chan struct{}
+	client   *http.Client
+}
+
+var (
+	lock    sync.Mutex
+	current *exporter
+)
+
+// New starts exporter of finished spans. Spans are written as OTLP/JSON
+// ExportTraceServiceRequest: one request per line to file and/or POSTed
+// to endpoint of collector.
+func New(cfg config.ConfigData) error {
+	p := &exporter{
+		service:  cfg.GetStringOr("service", defaultService),
+		file:     cfg.GetStringOr("file", ""),
+		endpoint: cfg.GetStringOr("endpoint", ""),
+		batch:    cfg.GetIntOr("batch-size", defaultBatchSize),
+		interval: time.Duration(cfg.GetIntOr("interval", defaultInterval)) * time.Second,
+		spans:    make(chan *Span, queueSize),
+		done:     make(chan struct{}),
+		client:   &http.Client{Timeout: 10 * time.Second}}
+	if len(p.file) == 0 && len(p.endpoint) == 0 {
+		return fmt.Errorf("tracing: file or endpoint required")
+	}
+	if p.batch <= 0 {
+		p.batch = defaultBatchSize
+	}
+	go p.run()
+
+	lock.Lock()
+	defer lock.Unlock()
+	current = p
+	return nil
+}
+
+// Shutdown flushes spans in queue and stops exporter.
+func Shutdown(ctx context.Context) error {
+	lock.Lock()
+	p := current
+	current = nil
+	lock.Unlock()
+	if p == nil {
+		return nil
+	}
+	close(p.spans)
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func export(s *Span) {
+	lock.Lock()
+	defer lock.Unlock()
+	if current == nil {
+		return
+	}
+	select {
+	case current.spans <- s:
+	default:
+		logger.Log.Warnf("tracing: queue is full, span %s dropped", s.Name)
+	}
+}
+
+func (p *exporter) run() {
+	defer close(p.done)
+	batch := make([]*Span, 0, p.batch)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case s, ok := <-p.spans:
+			if !ok {
+				p.flush(batch)
+				return
+			}
+			if batch = append(batch, s); len(batch) >= p.batch {
+				p.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			p.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (p *exporter) request(spans []*Span) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []Attribute{
+						Attribute{Key: "service.name", Value: Value{StringValue: p.service}}}},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "github.com/mhanygin/broforce/bus"},
+						"spans": spans}}}}})
+}
+
+func (p *exporter) flush(spans []*Span) {
+	if len(spans) == 0 {
+		return
+	}
+	data, err := p.request(spans)
+	if err != nil {
+		logger.Log.Errorf("tracing: %v", err)
+		return
+	}
+	if len(p.file) != 0 {
+		if err := p.write(data); err != nil {
+			logger.Log.Errorf("tracing: %v", err)
+		}
+	}
+	if len(p.endpoint) != 0 {
+		if err := p.post(data); err != nil {
+			logger.Log.Errorf("tracing: %v", err)
+		}
+	}
+}
+
+func (p *exporter) write(data []byte) error {
+	f, err := os.OpenFile(p.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+func (p *exporter) post(data []byte) error {
+	resp, err := p.client.Post(p.endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: status %d", p.endpoint, resp.StatusCode)
+	}
+	return nil
+}
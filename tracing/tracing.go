@@ -0,0 +1,99 @@
+package tracing
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ProducerKind = 4
+	ConsumerKind = 5
+
+	okStatus    = 1
+	errorStatus = 2
+)
+
+// Span follows OpenTelemetry span data model, JSON tags are OTLP/JSON.
+type Span struct {
+	TraceID      string      `json:"traceId"`
+	SpanID       string      `json:"spanId"`
+	ParentSpanID string      `json:"parentSpanId,omitempty"`
+	Name         string      `json:"name"`
+	Kind         int         `json:"kind"`
+	StartTime    string      `json:"startTimeUnixNano"`
+	EndTime      string      `json:"endTimeUnixNano"`
+	Attributes   []Attribute `json:"attributes,omitempty"`
+	Status       Status      `json:"status"`
+
+	start time.Time
+	once  sync.Once
+}
+
+type Attribute struct {
+	Key   string `json:"key"`
+	Value Value  `json:"value"`
+}
+
+type Value struct {
+	StringValue string `json:"stringValue"`
+}
+
+type Status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// TraceID converts Event.Trace to 16 bytes hex trace id: UUID as is,
+// any other string by hash.
+func TraceID(trace string) string {
+	id := strings.Replace(trace, "-", "", -1)
+	if _, err := hex.DecodeString(id); err == nil && len(id) == 32 {
+		return strings.ToLower(id)
+	}
+	sum := md5.Sum([]byte(trace))
+	return hex.EncodeToString(sum[:])
+}
+
+func newSpanID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Start begins span of trace, parent is span id of the caller or empty
+// for root span.
+func Start(trace string, parent string, name string, kind int) *Span {
+	return &Span{
+		TraceID:      TraceID(trace),
+		SpanID:       newSpanID(),
+		ParentSpanID: parent,
+		Name:         name,
+		Kind:         kind,
+		start:        time.Now()}
+}
+
+func (p *Span) ID() string {
+	return p.SpanID
+}
+
+func (p *Span) SetAttribute(key string, value string) {
+	p.Attributes = append(p.Attributes, Attribute{Key: key, Value: Value{StringValue: value}})
+}
+
+// End finishes span with status by err and passes it to exporter.
+func (p *Span) End(err error) {
+	p.once.Do(func() {
+		p.StartTime = strconv.FormatInt(p.start.UnixNano(), 10)
+		p.EndTime = strconv.FormatInt(time.Now().UnixNano(), 10)
+		p.Status = Status{Code: okStatus}
+		if err != nil {
+			p.Status = Status{Code: errorStatus, Message: err.Error()}
+		}
+		export(p)
+	})
+}
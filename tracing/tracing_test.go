@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mhanygin/broforce/config"
+	"github.com/mhanygin/broforce/logger"
+)
+
+type request struct {
+	ResourceSpans []struct {
+		ScopeSpans []struct {
+			Spans []Span `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+func TestTraceID(t *testing.T) {
+	assert.Equal(t, "0f5e6a1e2d8c4b7f9a3c1d2e4f5a6b7c", TraceID("0F5E6A1E-2D8C-4B7F-9A3C-1D2E4F5A6B7C"))
+	assert.Equal(t, 32, len(TraceID("not uuid")))
+	assert.Equal(t, TraceID("not uuid"), TraceID("not uuid"))
+}
+
+func TestExport(t *testing.T) {
+	spans, err := ioutil.TempFile("/tmp", "spans_")
+	assert.NoError(t, err)
+	spans.Close()
+	defer os.Remove(spans.Name())
+
+	posted := make(chan request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := request{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		posted <- req
+	}))
+	defer srv.Close()
+
+	tmpfile, err := ioutil.TempFile("/tmp", "config_")
+	assert.NoError(t, err)
+	fmt.Fprintf(tmpfile, "tracing:\n  file: %s\n  endpoint: %s\n", spans.Name(), srv.URL)
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	cfg, err := config.Load(tmpfile.Name(), config.YAMLAdapter)
+	assert.NoError(t, err)
+	logger.New(cfg.Get("logger"))
+
+	Start("trace", "", "disabled", ProducerKind).End(nil)
+	assert.NoError(t, New(cfg.Get("tracing")))
+
+	publish := Start("trace", "", "serve publish", ProducerKind)
+	publish.End(nil)
+	process := Start("trace", publish.ID(), "serve process", ConsumerKind)
+	process.SetAttribute("handler", "ServeHandler")
+	process.End(fmt.Errorf("failed"))
+	assert.NoError(t, Shutdown(context.Background()))
+
+	f, err := os.Open(spans.Name())
+	assert.NoError(t, err)
+	defer f.Close()
+	lines := 0
+	req := request{}
+	for s := bufio.NewScanner(f); s.Scan(); lines++ {
+		assert.NoError(t, json.Unmarshal(s.Bytes(), &req))
+	}
+	assert.Equal(t, 1, lines)
+	select {
+	case got := <-posted:
+		assert.Equal(t, req, got)
+	case <-time.After(5 * time.Second):
+		t.Fatal("spans not posted")
+	}
+
+	got := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if assert.Equal(t, 2, len(got)) {
+		assert.Equal(t, TraceID("trace"), got[1].TraceID)
+		assert.Equal(t, got[0].SpanID, got[1].ParentSpanID)
+		assert.Equal(t, ConsumerKind, got[1].Kind)
+		assert.Equal(t, Status{Code: errorStatus, Message: "failed"}, got[1].Status)
+		assert.Equal(t, okStatus, got[0].Status.Code)
+		assert.Equal(t, []Attribute{Attribute{Key: "handler", Value: Value{StringValue: "ServeHandler"}}}, got[1].Attributes)
+	}
+}